@@ -23,38 +23,65 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/tenants": {
+        "/bandwidth": {
             "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Retrieve a list of all tenants",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "List the upload and download throttles of the tenants that have sent traffic, with their caps, burst allowance left and current throughput",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "tenants"
+                    "rate-limits"
+                ],
+                "summary": "List bandwidth throttles",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only show the throttles of this tenant name",
+                        "name": "tenant",
+                        "in": "query"
+                    }
                 ],
-                "summary": "Get all tenants",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/models.Tenant"
+                                "$ref": "#/definitions/models.BandwidthThrottle"
                             }
                         }
-                    },
-                    "500": {
-                        "description": "Internal Server Error",
+                    }
+                }
+            }
+        },
+        "/blocklist": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List the destinations blocked for every tenant: the Blacklist entries of the global blocklist file, without imported lists, then the blocks added through the API",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "blocklist"
+                ],
+                "summary": "List the global blocklist",
+                "responses": {
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.ErrorResponse"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.GlobalBlock"
+                            }
                         }
                     }
                 }
@@ -65,7 +92,7 @@ const docTemplate = `{
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Create a new tenant with the provided name",
+                "description": "Add an entry to the global blocklist. The pattern takes the same forms as Blacklist entries. Global blocks are checked before any tenant rule or exception, and reach every proxy instance sharing the database within acl-global-sync-interval",
                 "consumes": [
                     "application/json"
                 ],
@@ -73,25 +100,25 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "tenants"
+                    "blocklist"
                 ],
-                "summary": "Create a new tenant",
+                "summary": "Block a destination for every tenant",
                 "parameters": [
                     {
-                        "description": "Tenant creation request",
+                        "description": "Global block",
                         "name": "body",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.CreateTenantRequest"
+                            "$ref": "#/definitions/models.GlobalBlockRequest"
                         }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "201": {
+                        "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/models.Tenant"
+                            "$ref": "#/definitions/models.GlobalBlock"
                         }
                     },
                     "400": {
@@ -99,42 +126,51 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
                     }
                 }
             }
         },
-        "/tenants/{tenantID}": {
-            "get": {
+        "/blocklist/{blockID}": {
+            "delete": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Retrieve a tenant by its ID",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Remove an entry added to the global blocklist through the API. Entries of the global blocklist file are removed by editing the file",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "tenants"
+                    "blocklist"
                 ],
-                "summary": "Get a tenant by ID",
+                "summary": "Remove a global block",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Tenant ID",
-                        "name": "tenantID",
+                        "description": "Block ID",
+                        "name": "blockID",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Who removes the block, for the logs",
+                        "name": "removed_by",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "OK",
+                        "description": "Global block removed successfully",
                         "schema": {
-                            "$ref": "#/definitions/models.Tenant"
+                            "type": "string"
                         }
                     },
                     "400": {
@@ -148,16 +184,60 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
                     }
                 }
-            },
-            "put": {
+            }
+        },
+        "/concurrency": {
+            "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Update a tenant's name by its ID",
+                "description": "List the tunnels and HTTP requests that tenants and API keys have open right now, against their caps, and how many were refused for hitting a cap. A max of 0 means unlimited",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rate-limits"
+                ],
+                "summary": "List concurrency caps",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only show the caps of this tenant name",
+                        "name": "tenant",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.ConcurrencySlot"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/explain": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Decide on a batch of destinations and compare each decision with the one expected, for instance to test ACL changes in CI before merging them. Tenants are named as their ACL files. The lists object optionally maps tenant names to the content of candidate ACL files, which are used instead of the loaded ones for this batch only. The response passes when every case with an expected decision gets it",
                 "consumes": [
                     "application/json"
                 ],
@@ -167,30 +247,23 @@ const docTemplate = `{
                 "tags": [
                     "tenants"
                 ],
-                "summary": "Update a tenant by ID",
+                "summary": "Explain ACL decisions in a batch",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Tenant ID",
-                        "name": "tenantID",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "description": "Tenant object",
+                        "description": "Cases to decide on",
                         "name": "body",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.CreateTenantRequest"
+                            "$ref": "#/definitions/models.ACLExplainBatchRequest"
                         }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Tenant updated successfully",
+                        "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/models.ACLExplainBatchResponse"
                         }
                     },
                     "400": {
@@ -206,63 +279,77 @@ const docTemplate = `{
                         }
                     }
                 }
-            },
-            "delete": {
+            }
+        },
+        "/rate-limits": {
+            "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Delete a tenant by its ID",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "List the token buckets of the tenants and API keys that have sent traffic recently, with their remaining tokens and how many operations were throttled",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "tenants"
+                    "rate-limits"
                 ],
-                "summary": "Delete a tenant by ID",
+                "summary": "List rate limit buckets",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Tenant ID",
-                        "name": "tenantID",
-                        "in": "path",
-                        "required": true
+                        "description": "Only show the buckets of this tenant name",
+                        "name": "tenant",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "Tenant deleted successfully",
-                        "schema": {
-                            "type": "string"
-                        }
-                    },
-                    "400": {
-                        "description": "Bad Request",
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.ErrorResponse"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.RateLimitBucket"
+                            }
                         }
-                    },
-                    "500": {
-                        "description": "Internal Server Error",
+                    }
+                }
+            }
+        },
+        "/scheduler": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get the capacity of the fair admission scheduler, the requests in service and waiting, and per tenant its weight, priority, current guaranteed share and how many requests were admitted or timed out in the queue",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rate-limits"
+                ],
+                "summary": "Get scheduler state",
+                "responses": {
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.ErrorResponse"
+                            "$ref": "#/definitions/models.SchedulerState"
                         }
                     }
                 }
             }
         },
-        "/{tenantID}/api-keys": {
+        "/tenants": {
             "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Get the API keys associated with the given tenantID, only API keys id are shown.",
+                "description": "Retrieve a list of all tenants",
                 "consumes": [
                     "application/json"
                 ],
@@ -270,36 +357,21 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "api-keys"
-                ],
-                "summary": "Get Tenant API keys IDs",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "Tenant ID",
-                        "name": "tenantID",
-                        "in": "path",
-                        "required": true
-                    }
+                    "tenants"
                 ],
+                "summary": "Get all tenants",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/models.APIKey"
+                                "$ref": "#/definitions/models.Tenant"
                             }
                         }
                     },
-                    "400": {
-                        "description": "Bad Request",
-                        "schema": {
-                            "$ref": "#/definitions/models.ErrorResponse"
-                        }
-                    },
-                    "404": {
-                        "description": "Not Found",
+                    "500": {
+                        "description": "Internal Server Error",
                         "schema": {
                             "$ref": "#/definitions/models.ErrorResponse"
                         }
@@ -312,7 +384,7 @@ const docTemplate = `{
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Create a new API key for a tenant",
+                "description": "Create a new tenant with the provided name",
                 "consumes": [
                     "application/json"
                 ],
@@ -320,36 +392,44 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "api-keys"
+                    "tenants"
                 ],
-                "summary": "Create an API key",
+                "summary": "Create a new tenant",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Tenant ID",
-                        "name": "tenantID",
-                        "in": "path",
-                        "required": true
+                        "description": "Tenant creation request",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.CreateTenantRequest"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/models.APIKey"
+                            "$ref": "#/definitions/models.Tenant"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
                         }
                     }
                 }
             }
         },
-        "/{tenantID}/api-keys/{apiKeyID}": {
-            "delete": {
+        "/tenants/{tenantID}": {
+            "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Delete the API key associated with the given ID and tenant",
+                "description": "Retrieve a tenant by its ID",
                 "consumes": [
                     "application/json"
                 ],
@@ -357,9 +437,9 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "api-keys"
+                    "tenants"
                 ],
-                "summary": "Delete API key",
+                "summary": "Get a tenant by ID",
                 "parameters": [
                     {
                         "type": "string",
@@ -367,20 +447,13 @@ const docTemplate = `{
                         "name": "tenantID",
                         "in": "path",
                         "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "API Key ID",
-                        "name": "apiKeyID",
-                        "in": "path",
-                        "required": true
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "API key deleted successfully",
+                        "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/models.Tenant"
                         }
                     },
                     "400": {
@@ -396,16 +469,14 @@ const docTemplate = `{
                         }
                     }
                 }
-            }
-        },
-        "/{tenantID}/api-keys/{apiKeyID}/rotate": {
+            },
             "put": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Rotate the API key associated with the given ID and tenant, generating a new key while keeping the same ID",
+                "description": "Update a tenant's name by its ID",
                 "consumes": [
                     "application/json"
                 ],
@@ -413,9 +484,688 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "api-keys"
+                    "tenants"
                 ],
-                "summary": "Rotate API key",
+                "summary": "Update a tenant by ID",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant ID",
+                        "name": "tenantID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Tenant object",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.CreateTenantRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Tenant updated successfully",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Delete a tenant by its ID",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tenants"
+                ],
+                "summary": "Delete a tenant by ID",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant ID",
+                        "name": "tenantID",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Tenant deleted successfully",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/tenants/{tenantID}/exceptions": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List the temporary allow and deny exceptions of a tenant that are in force",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tenants"
+                ],
+                "summary": "List ACL exceptions",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant ID",
+                        "name": "tenantID",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.ACLException"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Add a temporary allow or deny exception for a tenant. The pattern takes the same forms as Whitelist and Blacklist entries. Exceptions take precedence over the tenant's ACL file, deny before allow, and are removed automatically once they expire",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tenants"
+                ],
+                "summary": "Add an ACL exception",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant ID",
+                        "name": "tenantID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Exception",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.ACLExceptionRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.ACLException"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/tenants/{tenantID}/exceptions/audit": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List when each of a tenant's exceptions was created, revoked or expired, oldest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tenants"
+                ],
+                "summary": "Get the ACL exception audit trail",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant ID",
+                        "name": "tenantID",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.ACLExceptionAudit"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/tenants/{tenantID}/exceptions/{exceptionID}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Remove a tenant's exception before it expires. The revocation is recorded in the audit trail",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tenants"
+                ],
+                "summary": "Revoke an ACL exception",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant ID",
+                        "name": "tenantID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Exception ID",
+                        "name": "exceptionID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Who revokes the exception, for the audit trail",
+                        "name": "revoked_by",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Exception revoked successfully",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/tenants/{tenantID}/explain": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Decide whether a tenant may reach a destination, as the proxy would, and explain why: the layer, list and pattern that decided, every layer consulted before it, and how each matching pattern was compiled. Nothing is sent to the destination",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tenants"
+                ],
+                "summary": "Explain an ACL decision",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant ID",
+                        "name": "tenantID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Destination host name or IP address",
+                        "name": "host",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Destination port; defaults to 443 for CONNECT, and otherwise to that of the scheme",
+                        "name": "port",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "HTTP method of the request; CONNECT is evaluated as a tunnel, anything else as a plain HTTP request",
+                        "name": "method",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "http",
+                            "https"
+                        ],
+                        "type": "string",
+                        "description": "URI scheme of a plain HTTP request, http (the default) or https, which sets the default port to 80 or 443",
+                        "name": "scheme",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.ACLExplanation"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/tenants/{tenantID}/imports": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List the external list files imported by a tenant's ACL file and its policy groups, as of their last successful load, with the number of domains imported and the lines that were unsupported or could not be parsed",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tenants"
+                ],
+                "summary": "List imported lists",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant ID",
+                        "name": "tenantID",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.ImportReport"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/tenants/{tenantID}/usage": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get the requests and bytes a tenant has used in the current period, in total and per API key, and the quota they count against. The period is the quota's, or the one given, defaulting to daily. API key \"\" holds traffic without a key",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tenants"
+                ],
+                "summary": "Get tenant usage",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant ID",
+                        "name": "tenantID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "daily or monthly, when the tenant has no quota",
+                        "name": "period",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.TenantUsage"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/{tenantID}/api-keys": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Get the API keys associated with the given tenantID, only API keys id are shown.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "api-keys"
+                ],
+                "summary": "Get Tenant API keys IDs",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant ID",
+                        "name": "tenantID",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.APIKey"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Create a new API key for a tenant",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "api-keys"
+                ],
+                "summary": "Create an API key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant ID",
+                        "name": "tenantID",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.APIKey"
+                        }
+                    }
+                }
+            }
+        },
+        "/{tenantID}/api-keys/{apiKeyID}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Delete the API key associated with the given ID and tenant",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "api-keys"
+                ],
+                "summary": "Delete API key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tenant ID",
+                        "name": "tenantID",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "API Key ID",
+                        "name": "apiKeyID",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "API key deleted successfully",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/{tenantID}/api-keys/{apiKeyID}/rotate": {
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Rotate the API key associated with the given ID and tenant, generating a new key while keeping the same ID",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "api-keys"
+                ],
+                "summary": "Rotate API key",
                 "parameters": [
                     {
                         "type": "string",
@@ -431,31 +1181,336 @@ const docTemplate = `{
                         "in": "path",
                         "required": true
                     }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "OK",
-                        "schema": {
-                            "$ref": "#/definitions/models.APIKey"
-                        }
-                    },
-                    "400": {
-                        "description": "Bad Request",
-                        "schema": {
-                            "$ref": "#/definitions/models.ErrorResponse"
-                        }
-                    },
-                    "404": {
-                        "description": "Not Found",
-                        "schema": {
-                            "$ref": "#/definitions/models.ErrorResponse"
-                        }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.APIKey"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/models.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "models.ACLException": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "created_by": {
+                    "type": "string"
+                },
+                "exception_id": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "pattern": {
+                    "type": "string"
+                },
+                "protocols": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "tenant_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.ACLExceptionAudit": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "actor": {
+                    "type": "string"
+                },
+                "at": {
+                    "type": "string"
+                },
+                "audit_id": {
+                    "type": "string"
+                },
+                "event": {
+                    "type": "string"
+                },
+                "exception_id": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "pattern": {
+                    "type": "string"
+                },
+                "protocols": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.ACLExceptionRequest": {
+            "type": "object",
+            "required": [
+                "action",
+                "expires_at",
+                "pattern",
+                "reason"
+            ],
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "created_by": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "pattern": {
+                    "type": "string"
+                },
+                "protocols": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.ACLExplainBatchRequest": {
+            "type": "object",
+            "required": [
+                "cases"
+            ],
+            "properties": {
+                "cases": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ACLExplainCase"
+                    }
+                },
+                "lists": {
+                    "type": "object"
+                }
+            }
+        },
+        "models.ACLExplainBatchResponse": {
+            "type": "object",
+            "properties": {
+                "failed": {
+                    "type": "integer"
+                },
+                "passed": {
+                    "type": "boolean"
+                },
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ACLExplainResult"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.ACLExplainCase": {
+            "type": "object",
+            "required": [
+                "host",
+                "tenant"
+            ],
+            "properties": {
+                "expect": {
+                    "type": "string",
+                    "enum": [
+                        "allow",
+                        "block"
+                    ]
+                },
+                "host": {
+                    "type": "string"
+                },
+                "method": {
+                    "type": "string"
+                },
+                "port": {
+                    "type": "string"
+                },
+                "scheme": {
+                    "type": "string",
+                    "enum": [
+                        "http",
+                        "https"
+                    ]
+                },
+                "tenant": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.ACLExplainResult": {
+            "type": "object",
+            "required": [
+                "host",
+                "tenant"
+            ],
+            "properties": {
+                "expect": {
+                    "type": "string",
+                    "enum": [
+                        "allow",
+                        "block"
+                    ]
+                },
+                "explanation": {
+                    "$ref": "#/definitions/models.ACLExplanation"
+                },
+                "host": {
+                    "type": "string"
+                },
+                "method": {
+                    "type": "string"
+                },
+                "passed": {
+                    "type": "boolean"
+                },
+                "port": {
+                    "type": "string"
+                },
+                "scheme": {
+                    "type": "string",
+                    "enum": [
+                        "http",
+                        "https"
+                    ]
+                },
+                "tenant": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.ACLExplanation": {
+            "type": "object",
+            "properties": {
+                "decided_by": {
+                    "$ref": "#/definitions/models.ACLStep"
+                },
+                "decision": {
+                    "type": "string"
+                },
+                "host": {
+                    "type": "string"
+                },
+                "port": {
+                    "type": "string"
+                },
+                "protocol": {
+                    "type": "string"
+                },
+                "steps": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ACLStep"
+                    }
+                },
+                "tenant": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.ACLRuleMatch": {
+            "type": "object",
+            "properties": {
+                "block_id": {
+                    "type": "string"
+                },
+                "exception_id": {
+                    "type": "string"
+                },
+                "kind": {
+                    "type": "string"
+                },
+                "matcher": {
+                    "type": "string"
+                },
+                "pattern": {
+                    "type": "string"
+                },
+                "protocols": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
                     }
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "schedule": {
+                    "type": "string"
                 }
             }
-        }
-    },
-    "definitions": {
+        },
+        "models.ACLStep": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "detail": {
+                    "type": "string"
+                },
+                "group": {
+                    "type": "string"
+                },
+                "layer": {
+                    "type": "string"
+                },
+                "list": {
+                    "type": "string"
+                },
+                "matched": {
+                    "type": "boolean"
+                },
+                "rule": {
+                    "$ref": "#/definitions/models.ACLRuleMatch"
+                }
+            }
+        },
         "models.APIKey": {
             "type": "object",
             "properties": {
@@ -476,6 +1531,58 @@ const docTemplate = `{
                 }
             }
         },
+        "models.BandwidthThrottle": {
+            "type": "object",
+            "properties": {
+                "burst_bytes": {
+                    "type": "integer"
+                },
+                "bytes_per_second": {
+                    "type": "number"
+                },
+                "current_bytes_per_second": {
+                    "type": "number"
+                },
+                "direction": {
+                    "type": "string"
+                },
+                "tenant": {
+                    "type": "string"
+                },
+                "tokens_bytes": {
+                    "type": "number"
+                },
+                "total_bytes": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.ConcurrencySlot": {
+            "type": "object",
+            "properties": {
+                "api_key_id": {
+                    "type": "string"
+                },
+                "kind": {
+                    "type": "string"
+                },
+                "last_used": {
+                    "type": "string"
+                },
+                "max": {
+                    "type": "integer"
+                },
+                "open": {
+                    "type": "integer"
+                },
+                "rejected": {
+                    "type": "integer"
+                },
+                "tenant": {
+                    "type": "string"
+                }
+            }
+        },
         "models.CreateTenantRequest": {
             "type": "object",
             "required": [
@@ -495,6 +1602,209 @@ const docTemplate = `{
                 }
             }
         },
+        "models.GlobalBlock": {
+            "type": "object",
+            "properties": {
+                "block_id": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "created_by": {
+                    "type": "string"
+                },
+                "pattern": {
+                    "type": "string"
+                },
+                "protocols": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "source": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.GlobalBlockRequest": {
+            "type": "object",
+            "required": [
+                "pattern",
+                "reason"
+            ],
+            "properties": {
+                "created_by": {
+                    "type": "string"
+                },
+                "pattern": {
+                    "type": "string"
+                },
+                "protocols": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.ImportReport": {
+            "type": "object",
+            "properties": {
+                "entries": {
+                    "type": "integer"
+                },
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "format": {
+                    "type": "string"
+                },
+                "group": {
+                    "type": "string"
+                },
+                "into": {
+                    "type": "string"
+                },
+                "invalid": {
+                    "type": "integer"
+                },
+                "loaded_at": {
+                    "type": "string"
+                },
+                "path": {
+                    "type": "string"
+                },
+                "skipped": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.KeyUsage": {
+            "type": "object",
+            "properties": {
+                "bytes_in": {
+                    "type": "integer"
+                },
+                "bytes_out": {
+                    "type": "integer"
+                },
+                "requests": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.Quota": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "bytes": {
+                    "type": "integer"
+                },
+                "requests": {
+                    "type": "integer"
+                },
+                "throttle_bytes_per_second": {
+                    "type": "number"
+                }
+            }
+        },
+        "models.RateLimitBucket": {
+            "type": "object",
+            "properties": {
+                "allowed": {
+                    "type": "integer"
+                },
+                "api_key_id": {
+                    "type": "string"
+                },
+                "burst": {
+                    "type": "integer"
+                },
+                "kind": {
+                    "type": "string"
+                },
+                "last_used": {
+                    "type": "string"
+                },
+                "rate": {
+                    "type": "number"
+                },
+                "tenant": {
+                    "type": "string"
+                },
+                "throttled": {
+                    "type": "integer"
+                },
+                "tokens": {
+                    "type": "number"
+                }
+            }
+        },
+        "models.SchedulerState": {
+            "type": "object",
+            "properties": {
+                "capacity": {
+                    "type": "integer"
+                },
+                "in_use": {
+                    "type": "integer"
+                },
+                "max_wait": {
+                    "type": "string"
+                },
+                "tenants": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.SchedulerTenant"
+                    }
+                },
+                "waiting": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.SchedulerTenant": {
+            "type": "object",
+            "properties": {
+                "admitted": {
+                    "type": "integer"
+                },
+                "in_use": {
+                    "type": "integer"
+                },
+                "priority": {
+                    "type": "string"
+                },
+                "share": {
+                    "type": "number"
+                },
+                "tenant": {
+                    "type": "string"
+                },
+                "timed_out": {
+                    "type": "integer"
+                },
+                "waiting": {
+                    "type": "integer"
+                },
+                "weight": {
+                    "type": "number"
+                }
+            }
+        },
         "models.Tenant": {
             "type": "object",
             "properties": {
@@ -505,6 +1815,47 @@ const docTemplate = `{
                     "type": "string"
                 }
             }
+        },
+        "models.TenantUsage": {
+            "type": "object",
+            "properties": {
+                "bytes_in": {
+                    "type": "integer"
+                },
+                "bytes_out": {
+                    "type": "integer"
+                },
+                "exhausted": {
+                    "type": "boolean"
+                },
+                "keys": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/models.KeyUsage"
+                    }
+                },
+                "period": {
+                    "type": "string"
+                },
+                "period_start": {
+                    "type": "string"
+                },
+                "quota": {
+                    "$ref": "#/definitions/models.Quota"
+                },
+                "requests": {
+                    "type": "integer"
+                },
+                "resets_at": {
+                    "type": "string"
+                },
+                "tenant_id": {
+                    "type": "string"
+                },
+                "tenant_name": {
+                    "type": "string"
+                }
+            }
         }
     },
     "securityDefinitions": {
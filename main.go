@@ -15,6 +15,8 @@ import (
 	"github.com/clodevo/raven-proxy/pkg/config"
 	"github.com/clodevo/raven-proxy/pkg/database"
 	"github.com/clodevo/raven-proxy/pkg/proxy"
+	"github.com/clodevo/raven-proxy/pkg/ratelimit"
+	"github.com/clodevo/raven-proxy/pkg/usage"
 	"github.com/clodevo/raven-proxy/pkg/utils"
 
 	"github.com/appleboy/graceful"
@@ -59,6 +61,17 @@ func main() {
 
 	// Initialize ACLManager with the logger
 	aclManager := acl.NewACLManager(appConfig.ACLDataPath, utils.GetLogger())
+	if appConfig.GitSyncConfig.RepoURL != "" {
+		// Candidate lists may import lists from anywhere in the repository
+		aclManager.SetCandidateRoot(appConfig.GitSyncConfig.RepoPath)
+	}
+	aclManager.Watch()
+	aclManager.StartExceptionSync(appConfig.ExceptionSyncInterval)
+	aclManager.StartGlobalBlockSync(appConfig.GlobalBlockSyncInterval)
+	handlers.SetACLManager(aclManager)
+
+	// Flush traffic counters to the database in the background
+	usage.GetMeter().Start(appConfig.UsageFlushInterval)
 
 	// Admin API key and ACL data path are now directly accessible
 	adminAPIKey = appConfig.AdminAPIKey
@@ -118,11 +131,28 @@ func setupAuthenticatedRoutes(group *gin.RouterGroup) {
 	group.POST("/tenants", handlers.TenantsHandler)
 	group.PUT("/tenants/:tenantID", handlers.TenantsHandler)
 	group.DELETE("/tenants/:tenantID", handlers.TenantsHandler)
+	group.GET("/tenants/:tenantID/usage", handlers.GetTenantUsage)
+	group.GET("/tenants/:tenantID/imports", handlers.GetTenantImports)
+	group.GET("/tenants/:tenantID/exceptions", handlers.GetTenantExceptions)
+	group.POST("/tenants/:tenantID/exceptions", handlers.CreateTenantException)
+	group.DELETE("/tenants/:tenantID/exceptions/:exceptionID", handlers.RevokeTenantException)
+	group.GET("/tenants/:tenantID/exceptions/audit", handlers.GetTenantExceptionAudit)
+	group.GET("/tenants/:tenantID/explain", handlers.ExplainTenantDecision)
+	group.POST("/explain", handlers.ExplainDecisions)
 
 	group.GET("/:tenantID/api-keys", handlers.GetTenantAPIKey)
 	group.POST("/:tenantID/api-keys", handlers.CreateAPIKey)
 	group.PUT("/:tenantID/api-keys/:apiKeyID/rotate", handlers.RotateAPIKey)
 	group.DELETE("/:tenantID/api-keys/:apiKeyID", handlers.DeleteAPIKey)
+
+	group.GET("/blocklist", handlers.GetGlobalBlocklist)
+	group.POST("/blocklist", handlers.CreateGlobalBlock)
+	group.DELETE("/blocklist/:blockID", handlers.RemoveGlobalBlock)
+
+	group.GET("/rate-limits", handlers.GetRateLimits)
+	group.GET("/bandwidth", handlers.GetBandwidth)
+	group.GET("/concurrency", handlers.GetConcurrency)
+	group.GET("/scheduler", handlers.GetScheduler)
 }
 
 // startAdminServer initializes and starts the Gin HTTP server.
@@ -144,6 +174,9 @@ func startAdminServer(router *gin.Engine, adminConfig *config.AppConfig) {
 
 // startProxyServer initializes and starts the FastHTTP server.
 func startProxyServer(proxyConfig *config.ProxyConfig, aclManager *acl.ACLManager) {
+	proxy.ConfigureResolver(proxyConfig)
+	ratelimit.GetScheduler().Configure(proxyConfig.SchedulerCapacity, proxyConfig.SchedulerMaxWait)
+
 	server := &fasthttp.Server{
 		Handler:            fasthttp.CompressHandler(proxy.FastHTTPHandler(proxyConfig, aclManager)),
 		ReadTimeout:        proxyConfig.Timeout,
@@ -162,6 +195,38 @@ func startProxyServer(proxyConfig *config.ProxyConfig, aclManager *acl.ACLManage
 			fmt.Printf("Error in Proxy ListenAndServe: %s\n", err)
 		}
 	}()
+
+	if proxyConfig.TLSAddr != "" {
+		ln, err := proxy.NewTLSListener(proxyConfig)
+		if err != nil {
+			fmt.Printf("Error in TLS Proxy Listen: %s\n", err)
+		} else {
+			go func() {
+				fmt.Printf("TLS proxy server started at %s\n", proxyConfig.TLSAddr)
+				if err := server.Serve(ln); err != nil {
+					fmt.Printf("Error in TLS Proxy Serve: %s\n", err)
+				}
+			}()
+		}
+	}
+
+	if proxyConfig.SOCKS5Addr != "" {
+		go func() {
+			fmt.Printf("SOCKS5 proxy server started at %s\n", proxyConfig.SOCKS5Addr)
+			if err := proxy.ListenAndServeSOCKS5(proxyConfig, aclManager); err != nil {
+				fmt.Printf("Error in SOCKS5 ListenAndServe: %s\n", err)
+			}
+		}()
+	}
+
+	if proxyConfig.TransparentAddr != "" {
+		go func() {
+			fmt.Printf("Transparent proxy server (%s) started at %s\n", proxyConfig.TransparentMode, proxyConfig.TransparentAddr)
+			if err := proxy.ListenAndServeTransparent(proxyConfig, aclManager); err != nil {
+				fmt.Printf("Error in Transparent ListenAndServe: %s\n", err)
+			}
+		}()
+	}
 }
 
 // waitForShutdown handles graceful shutdown on interrupt signals.
@@ -169,6 +234,9 @@ func waitForShutdown() {
 	graceful.NewManager().AddRunningJob(func(ctx context.Context) error {
 		<-ctx.Done()
 		fmt.Println("Servers are shutting down")
+		if err := usage.GetMeter().Flush(); err != nil {
+			fmt.Printf("Error flushing usage counters: %s\n", err)
+		}
 		// Implement additional shutdown logic if necessary.
 		return nil
 	})
@@ -1,118 +1,208 @@
 package acl
 
 import (
-	"encoding/json"
-	"io/ioutil"
 	"net"
+	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/clodevo/raven-proxy/pkg/utils"
 	"github.com/valyala/fasthttp"
 )
 
-// Assuming LogLevelNone, LogLevelDebug, LogLevelTrace are defined in utils package
-// If not, you'll need to adjust the log level handling accordingly.
-
-type List struct {
-	Whitelist []string `json:"Whitelist"`
-	Blacklist []string `json:"Blacklist"`
-}
-
+// ACLManager holds the compiled ACL policies of every tenant. The policies
+// are kept in an immutable snapshot that is replaced atomically when the
+// ACL files change, so request goroutines never block on a reload.
 type ACLManager struct {
-	TenantLists      map[string]*List
-	compiledPatterns map[string]*regexp.Regexp
-	aclDataPath      string
-	logger           *utils.Logger
+	policies    atomic.Pointer[map[string]*Policy]
+	reloadMu    sync.Mutex
+	groups      map[string]*Policy // guarded by reloadMu
+	aclDataPath string
+	logger      *utils.Logger
+	now         func() time.Time
+	// candidateRoot is the directory that candidate lists may read files
+	// from (see CompileList).
+	candidateRoot string
+
+	// exceptions holds the exceptions of every tenant by tenant name, in
+	// a snapshot replaced by SyncExceptions.
+	exceptions   atomic.Pointer[map[string][]*Exception]
+	exceptionsMu sync.Mutex
+
+	// global is the global blocklist, which applies to every tenant before
+	// its own rules. globalMu serializes updates of the snapshot and
+	// globalSyncMu the syncs with the database.
+	global       atomic.Pointer[globalBlocklist]
+	globalMu     sync.Mutex
+	globalSyncMu sync.Mutex
+	// defaultAddresses applies to tenants without an ACL file.
+	defaultAddresses *AddressPolicy
 }
 
 func NewACLManager(aclDataPath string, logger *utils.Logger) *ACLManager {
-	return &ACLManager{
-		TenantLists:      make(map[string]*List),
-		compiledPatterns: make(map[string]*regexp.Regexp),
-		aclDataPath:      aclDataPath,
-		logger:           logger,
+	a := &ACLManager{
+		aclDataPath:   aclDataPath,
+		logger:        logger,
+		now:           time.Now,
+		candidateRoot: aclDataPath,
 	}
+	a.policies.Store(&map[string]*Policy{})
+	a.exceptions.Store(&map[string][]*Exception{})
+	a.global.Store(&globalBlocklist{rules: newRuleSet(nil)})
+	a.defaultAddresses, _ = compileAddresses(nil, nil, nil, nil)
+	a.defaultAddresses.bind(a, "")
+	a.Reload()
+	return a
 }
 
-func (a *ACLManager) LoadTenantLists(tenantName string) *List {
-	list := &List{}
-	filePath := filepath.Join(a.aclDataPath, tenantName+".json")
-	fileContent, err := ioutil.ReadFile(filePath)
-	if err != nil {
-		a.logger.Debug("Error reading list file for tenant %s: %v", tenantName, err)
-		return list
-	}
+// SetClock replaces the clock that scheduled rules are evaluated against,
+// which is the system clock by default. It is meant for tests and must be
+// called before the manager is used.
+func (a *ACLManager) SetClock(now func() time.Time) {
+	a.now = now
+}
 
-	err = json.Unmarshal(fileContent, list)
-	if err != nil {
-		a.logger.Debug("Error parsing list file for tenant %s: %v", tenantName, err)
-		return list
-	}
+// clock returns the current time of the manager's clock.
+func (a *ACLManager) clock() time.Time {
+	return a.now()
+}
 
-	a.TenantLists[tenantName] = list
-	a.logger.Trace("Loaded ACL list for tenant: %s", tenantName)
-	return list
+// Policy returns the current policy of a tenant, or nil if the tenant has no ACL file.
+func (a *ACLManager) Policy(tenantName string) *Policy {
+	return (*a.policies.Load())[tenantName]
 }
 
-func (a *ACLManager) IsRequestAllowed(ctx *fasthttp.RequestCtx, tenantName string) bool {
-	hostWithPort := string(ctx.Host())
-	host, port, _ := net.SplitHostPort(hostWithPort)
-	if host == "" {
-		host = hostWithPort
+// Reload rescans aclDataPath, its groups directory and the global
+// blocklist file and publishes a new snapshot. Files that have not changed
+// since the last reload are reused as is, and files that fail to parse
+// keep their last good version. Tenants are recompiled when one of their
+// groups changes.
+func (a *ACLManager) Reload() {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	a.reloadGlobal()
+	groups := a.reloadGroups()
+
+	files, err := filepath.Glob(filepath.Join(a.aclDataPath, "*.json"))
+	if err != nil {
+		a.logger.Info("Error listing ACL files in %s: %v", a.aclDataPath, err)
+		return
 	}
 
-	if list, exists := a.TenantLists[tenantName]; exists {
-		for _, b := range list.Blacklist {
-			if a.matchesPattern(host, port, b) {
-				a.logger.Debug("Request to %s blocked by blacklist rule: %s", hostWithPort, b)
-				return false
-			}
+	current := *a.policies.Load()
+	next := make(map[string]*Policy, len(files))
+	for _, filePath := range files {
+		tenantName := strings.TrimSuffix(filepath.Base(filePath), ".json")
+		previous := current[tenantName]
+
+		info, err := os.Stat(filePath)
+		if err != nil {
+			a.logger.Debug("Error reading list file for tenant %s: %v", tenantName, err)
+			continue
 		}
-		for _, w := range list.Whitelist {
-			if a.matchesPattern(host, port, w) {
-				a.logger.Debug("Request to %s allowed by whitelist rule: %s", hostWithPort, w)
-				return true
+		if previous != nil && previous.modTime.Equal(info.ModTime()) && previous.size == info.Size() &&
+			!previous.sourcesChanged() && previous.sameGroups(groups) {
+			next[tenantName] = previous
+			continue
+		}
+
+		policy, err := loadPolicy(tenantName, filePath, info, groups)
+		if err != nil {
+			a.logger.Info("Error loading list file for tenant %s, keeping last good version: %v", tenantName, err)
+			if previous != nil {
+				next[tenantName] = previous
 			}
+			continue
 		}
-		a.logger.Trace("Evaluating request to %s against ACL rules", hostWithPort)
-		return false
+		policy.Addresses.bind(a, tenantName)
+		next[tenantName] = policy
+		a.logger.Debug("Loaded ACL list for tenant: %s", tenantName)
+		a.logImports(policy.Imports, "tenant "+tenantName)
 	}
-	a.logger.Trace("No ACL rules defined for tenant %s, defaulting to block", tenantName)
-	return false
-}
 
-func (a *ACLManager) matchesPattern(host, port, pattern string) bool {
-	patternHost, patternPort, _ := net.SplitHostPort(pattern)
-	if patternHost == "" {
-		patternHost = pattern
+	for tenantName := range current {
+		if _, exists := next[tenantName]; !exists {
+			a.logger.Debug("Removed ACL list for tenant: %s", tenantName)
+		}
 	}
 
-	if patternPort == "" || patternPort == port {
-		regex := a.compilePattern(patternHost)
-		match := regex.MatchString(host)
-		a.logger.Trace("Matching host %s against pattern %s: %t", host, regex.String(), match)
-		return match
+	a.policies.Store(&next)
+}
+
+// logImports reports the outcome of the lists imported by a tenant or group.
+func (a *ACLManager) logImports(reports []ImportReport, owner string) {
+	for _, report := range reports {
+		a.logger.Info("Imported %d domains from %s (%s) into the %s of %s, %d unsupported and %d invalid lines",
+			report.Entries, report.Path, report.Format, report.Into, owner, report.Skipped, report.Invalid)
+		for _, message := range report.Errors {
+			a.logger.Info("Invalid line in %s: %s", report.Path, message)
+		}
 	}
-	return false
 }
 
-func (a *ACLManager) wildcardToRegex(pattern string) string {
-	pattern = strings.Replace(pattern, "*", ".*", -1)
-	pattern = strings.Replace(pattern, ".", "\\.", -1)  // Escape actual dots for regex
-	pattern = strings.Replace(pattern, "\\.*", ".*", 1) // Replace the first occurrence of '\.*' with '.*'
+// IsRequestAllowed evaluates the request against the ACL of the tenant the
+// identity belongs to. CONNECT requests are evaluated as ProtocolConnect,
+// everything else as ProtocolHTTP with the scheme's default port when the
+// Host has none.
+func (a *ACLManager) IsRequestAllowed(ctx *fasthttp.RequestCtx, id *utils.Identity) bool {
+	if ctx.IsConnect() {
+		return a.IsHostAllowed(id, string(ctx.Host()), ProtocolConnect)
+	}
+	return a.IsHostAllowed(id, hostWithDefaultPort(ctx), ProtocolHTTP)
+}
 
-	// Make the regex case-insensitive and ensure it matches the entire host
-	return "(?i)^" + pattern + "$"
+// hostWithDefaultPort returns the request's Host with the port of its scheme added when it has none.
+func hostWithDefaultPort(ctx *fasthttp.RequestCtx) string {
+	host := string(ctx.Host())
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	port := "80"
+	if string(ctx.URI().Scheme()) == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
 }
 
-func (a *ACLManager) compilePattern(pattern string) *regexp.Regexp {
-	if compiled, exists := a.compiledPatterns[pattern]; exists {
-		return compiled
+// IsHostAllowed evaluates a host or host:port destination reached over the
+// given protocol (ProtocolConnect or ProtocolHTTP) against the ACL of the
+// tenant the identity belongs to. It is used directly by listeners that do
+// not carry an HTTP request, such as SOCKS5. Rules restricted to other
+// protocols are skipped. The global blocklist is checked first, before
+// the tenant's exceptions and rules.
+func (a *ACLManager) IsHostAllowed(id *utils.Identity, hostWithPort, protocol string) bool {
+	tenantName := id.TenantName
+	host, port, _ := net.SplitHostPort(hostWithPort)
+	if host == "" {
+		host = hostWithPort
+	}
+
+	policy := a.Policy(tenantName)
+	if policy != nil {
+		a.logger.Trace("[%s] Evaluating request to %s against ACL rules", id, hostWithPort)
+	}
+	step := a.decide(tenantName, policy, host, port, protocol, a.clock(), nil)
+	switch {
+	case step.Layer == LayerGlobal && step.Block != nil:
+		a.logger.Info("%s [%s] Request to %s blocked by global block %s for %s: %s", globalBlockTag, id, hostWithPort, step.Block.ID, step.Rule, step.Block.Reason)
+	case step.Layer == LayerGlobal:
+		a.logger.Info("%s [%s] Request to %s blocked by global blocklist file %s", globalBlockTag, id, hostWithPort, step.Rule)
+	case step.Layer == LayerExceptions && step.Allowed:
+		a.logger.Debug("[%s] Request to %s allowed by %s", id, hostWithPort, step.Exception)
+	case step.Layer == LayerExceptions:
+		a.logger.Debug("[%s] Request to %s blocked by %s", id, hostWithPort, step.Exception)
+	case step.Layer == LayerDefault && policy == nil:
+		a.logger.Trace("[%s] No ACL rules defined for tenant %s, defaulting to block", id, tenantName)
+	case step.Layer == LayerDefault:
+		a.logger.Trace("[%s] No ACL rule matches %s", id, hostWithPort)
+	case step.Allowed:
+		a.logger.Debug("[%s] Request to %s allowed by %s %s", id, hostWithPort, step.List, step.Rule)
+	default:
+		a.logger.Debug("[%s] Request to %s blocked by %s %s", id, hostWithPort, step.List, step.Rule)
 	}
-	regexPattern := a.wildcardToRegex(pattern)
-	regex := regexp.MustCompile(regexPattern)
-	a.compiledPatterns[pattern] = regex
-	return regex
+	return step.Allowed
 }
@@ -0,0 +1,272 @@
+package acl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// ErrAddressBlocked is returned (wrapped) when a destination resolves to an
+// address the tenant may not connect to.
+var ErrAddressBlocked = errors.New("destination address blocked by policy")
+
+// AddressList is the "Addresses" section of a tenant ACL file. It controls
+// which IP addresses the proxy connects to on the tenant's behalf, after
+// names have been resolved. Entries are CIDRs or single addresses. Deny
+// always wins; Allow opens up addresses that are blocked by default.
+//
+//	"Addresses": {
+//	  "Allow": ["10.20.0.0/16", "fd12:3456::/48"],
+//	  "Deny": ["203.0.113.7"]
+//	}
+type AddressList struct {
+	Allow []string `json:"Allow"`
+	Deny  []string `json:"Deny"`
+}
+
+// AddressPolicy is the compiled form of an AddressList, together with the
+// IP and CIDR rules of the tenant's blacklist, overrides and groups'
+// blacklists, which apply to the addresses names resolve to as well as to
+// addresses given directly.
+type AddressPolicy struct {
+	allow          []*net.IPNet
+	deny           []*net.IPNet
+	blacklist      []*Rule
+	overrides      []*Rule
+	groupBlacklist []*Rule
+	// now is the clock scheduled blacklist rules are evaluated against.
+	now func() time.Time
+	// global matches the IP and CIDR rules of the global blocklist that
+	// apply to protocol, which is empty for the policy itself and set for
+	// its variants.
+	global func(ip net.IP, port, protocol string) *Rule
+	// exceptions matches the tenant's IP and CIDR exceptions with an
+	// action that apply to protocol, like global.
+	exceptions func(action string, ip net.IP, port, protocol string) *Exception
+	protocol   string
+
+	// byProtocol holds variants whose blacklist is restricted to the rules
+	// that apply to each protocol.
+	byProtocol map[string]*AddressPolicy
+}
+
+// blockedRange is an address range the proxy refuses to connect to unless
+// the tenant allows it.
+type blockedRange struct {
+	network *net.IPNet
+	reason  string
+}
+
+// defaultBlocked lists the ranges that reach the proxy host itself, the
+// internal network or cloud metadata services. Metadata addresses come
+// first so that they are reported as such.
+var defaultBlocked = mustBlockedRanges([]struct{ reason, cidr string }{
+	{"cloud metadata", "169.254.169.254/32"},
+	{"cloud metadata", "fd00:ec2::254/128"},
+	{"cloud metadata", "100.100.100.200/32"},
+	{"unspecified", "0.0.0.0/8"},
+	{"unspecified", "::/128"},
+	{"loopback", "127.0.0.0/8"},
+	{"loopback", "::1/128"},
+	{"private", "10.0.0.0/8"},
+	{"private", "172.16.0.0/12"},
+	{"private", "192.168.0.0/16"},
+	{"private", "fc00::/7"},
+	{"shared", "100.64.0.0/10"},
+	{"link-local", "169.254.0.0/16"},
+	{"link-local", "fe80::/10"},
+	{"IETF protocol", "192.0.0.0/24"},
+	{"benchmarking", "198.18.0.0/15"},
+	{"multicast", "224.0.0.0/4"},
+	{"reserved", "240.0.0.0/4"},
+	{"IPv4 translated", "64:ff9b::/96"},
+	{"6to4", "2002::/16"},
+})
+
+func mustBlockedRanges(ranges []struct{ reason, cidr string }) []blockedRange {
+	blocked := make([]blockedRange, 0, len(ranges))
+	for _, r := range ranges {
+		_, network, err := net.ParseCIDR(r.cidr)
+		if err != nil {
+			panic(err)
+		}
+		blocked = append(blocked, blockedRange{network: network, reason: r.reason})
+	}
+	return blocked
+}
+
+func compileAddresses(list *AddressList, blacklist, overrides, groupBlacklist []*Rule) (*AddressPolicy, error) {
+	policy := &AddressPolicy{now: time.Now}
+	if list != nil {
+		var err error
+		if policy.allow, err = parseNetworks(list.Allow); err != nil {
+			return nil, fmt.Errorf("allow: %w", err)
+		}
+		if policy.deny, err = parseNetworks(list.Deny); err != nil {
+			return nil, fmt.Errorf("deny: %w", err)
+		}
+	}
+
+	policy.byProtocol = make(map[string]*AddressPolicy, 2)
+	for _, protocol := range []string{ProtocolConnect, ProtocolHTTP} {
+		policy.byProtocol[protocol] = &AddressPolicy{
+			allow:          policy.allow,
+			deny:           policy.deny,
+			now:            policy.now,
+			protocol:       protocol,
+			blacklist:      addressRules(blacklist, protocol),
+			overrides:      addressRules(overrides, protocol),
+			groupBlacklist: addressRules(groupBlacklist, protocol),
+		}
+	}
+	return policy, nil
+}
+
+// addressRules returns the IP and CIDR rules that apply to protocol.
+func addressRules(rules []*Rule, protocol string) []*Rule {
+	var matching []*Rule
+	for _, rule := range rules {
+		if rule.Network != nil && rule.appliesTo(protocol) {
+			matching = append(matching, rule)
+		}
+	}
+	return matching
+}
+
+// bind makes the policy and its variants evaluate scheduled rules against
+// the manager's clock and check the manager's global blocklist and the
+// exceptions of the tenant. It must be called before the policy is
+// published.
+func (p *AddressPolicy) bind(a *ACLManager, tenantName string) {
+	exceptions := a.exceptionIPs(tenantName)
+	p.now = a.clock
+	p.global = a.matchGlobalIP
+	p.exceptions = exceptions
+	for _, variant := range p.byProtocol {
+		variant.now = a.clock
+		variant.global = a.matchGlobalIP
+		variant.exceptions = exceptions
+	}
+}
+
+// parseNetworks parses CIDRs and single addresses, the latter as /32 or /128 networks.
+func parseNetworks(entries []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if strings.Contains(entry, "/") {
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, err
+			}
+			networks = append(networks, network)
+			continue
+		}
+		ip := net.ParseIP(strings.Trim(entry, "[]"))
+		if ip == nil {
+			return nil, fmt.Errorf("invalid address %q", entry)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return networks, nil
+}
+
+// Check returns an error wrapping ErrAddressBlocked when the tenant may not
+// connect to ip on port. IPv4-mapped IPv6 addresses are checked as IPv4.
+// Blacklist rules and exceptions apply in the same order as to
+// destinations: the global blocklist's, deny then allow exceptions, the
+// tenant's, then those of its groups unless one of its overrides covers
+// ip. An allow exception only skips the blacklists; the Addresses section
+// and the ranges blocked by default still apply.
+func (p *AddressPolicy) Check(ip net.IP, port string) error {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if p.global != nil {
+		if rule := p.global(ip, port, p.protocol); rule != nil {
+			return fmt.Errorf("%w: %s %s matches the global blocklist %s", ErrAddressBlocked, globalBlockTag, ip, rule)
+		}
+	}
+	excepted := false
+	if p.exceptions != nil {
+		if e := p.exceptions(ExceptionDeny, ip, port, p.protocol); e != nil {
+			return fmt.Errorf("%w: %s matches %s", ErrAddressBlocked, ip, e)
+		}
+		excepted = p.exceptions(ExceptionAllow, ip, port, p.protocol) != nil
+	}
+	if !excepted {
+		if rule := p.matchIP(p.blacklist, ip, port); rule != nil {
+			return fmt.Errorf("%w: %s matches blacklist %s", ErrAddressBlocked, ip, rule)
+		}
+		if p.matchIP(p.overrides, ip, port) == nil {
+			if rule := p.matchIP(p.groupBlacklist, ip, port); rule != nil {
+				return fmt.Errorf("%w: %s matches blacklist %s", ErrAddressBlocked, ip, rule)
+			}
+		}
+	}
+	if containsIP(p.deny, ip) {
+		return fmt.Errorf("%w: %s is denied for the tenant", ErrAddressBlocked, ip)
+	}
+	if containsIP(p.allow, ip) {
+		return nil
+	}
+	for _, blocked := range defaultBlocked {
+		if blocked.network.Contains(ip) {
+			return fmt.Errorf("%w: %s is in the %s range", ErrAddressBlocked, ip, blocked.reason)
+		}
+	}
+	return nil
+}
+
+// matchIP returns the first of rules that covers ip and port and is in force, or nil.
+func (p *AddressPolicy) matchIP(rules []*Rule, ip net.IP, port string) *Rule {
+	for _, rule := range rules {
+		if rule.matchesIP(ip, port) && (rule.Schedule == nil || rule.activeAt(p.now())) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddressesFor returns the address policy of a tenant for connections of
+// the given protocol (ProtocolConnect or ProtocolHTTP). Without an
+// Addresses section it blocks the internal ranges and the blacklisted
+// addresses only.
+func (a *ACLManager) AddressesFor(tenantName, protocol string) *AddressPolicy {
+	return a.addressesOf(tenantName, a.Policy(tenantName), protocol)
+}
+
+// addressesOf returns the address policy for connections of the given
+// protocol of a tenant with the given policy, which may be nil.
+func (a *ACLManager) addressesOf(tenantName string, policy *Policy, protocol string) *AddressPolicy {
+	addresses := a.defaultAddresses
+	if policy != nil {
+		addresses = policy.Addresses
+	}
+	if variant, exists := addresses.byProtocol[protocol]; exists {
+		addresses = variant
+	}
+	if policy == nil && len((*a.exceptions.Load())[tenantName]) > 0 {
+		// The default policy is shared, so it is bound to the tenant's
+		// exceptions on a copy.
+		bound := *addresses
+		bound.exceptions = a.exceptionIPs(tenantName)
+		addresses = &bound
+	}
+	return addresses
+}
@@ -0,0 +1,56 @@
+package acl
+
+import (
+	"fmt"
+
+	"github.com/clodevo/raven-proxy/pkg/ratelimit"
+)
+
+// BandwidthList is the "Bandwidth" section of a tenant ACL file. Upload and
+// download caps are shared by all of the tenant's connections; the
+// per-connection cap additionally applies to each connection in each
+// direction. A zero rate means unlimited.
+//
+//	"Bandwidth": {
+//	  "UploadBytesPerSecond": 1048576,
+//	  "DownloadBytesPerSecond": 10485760,
+//	  "BurstBytes": 2097152,
+//	  "PerConnectionBytesPerSecond": 1048576
+//	}
+type BandwidthList struct {
+	UploadBytesPerSecond        float64 `json:"UploadBytesPerSecond"`
+	DownloadBytesPerSecond      float64 `json:"DownloadBytesPerSecond"`
+	BurstBytes                  int     `json:"BurstBytes"`
+	PerConnectionBytesPerSecond float64 `json:"PerConnectionBytesPerSecond"`
+}
+
+// BandwidthPolicy is the compiled form of a BandwidthList.
+type BandwidthPolicy struct {
+	Upload        ratelimit.Limit
+	Download      ratelimit.Limit
+	PerConnection ratelimit.Limit
+}
+
+func compileBandwidth(list *BandwidthList) (*BandwidthPolicy, error) {
+	if list == nil {
+		return nil, nil
+	}
+	if list.UploadBytesPerSecond < 0 || list.DownloadBytesPerSecond < 0 || list.PerConnectionBytesPerSecond < 0 || list.BurstBytes < 0 {
+		return nil, fmt.Errorf("rates and burst must not be negative")
+	}
+	return &BandwidthPolicy{
+		Upload:        ratelimit.Limit{Rate: list.UploadBytesPerSecond, Burst: list.BurstBytes},
+		Download:      ratelimit.Limit{Rate: list.DownloadBytesPerSecond, Burst: list.BurstBytes},
+		PerConnection: ratelimit.Limit{Rate: list.PerConnectionBytesPerSecond, Burst: list.BurstBytes},
+	}, nil
+}
+
+// BandwidthFor returns the bandwidth caps of a tenant. Tenants without a
+// Bandwidth section are unlimited.
+func (a *ACLManager) BandwidthFor(tenantName string) BandwidthPolicy {
+	policy := a.Policy(tenantName)
+	if policy == nil || policy.Bandwidth == nil {
+		return BandwidthPolicy{}
+	}
+	return *policy.Bandwidth
+}
@@ -0,0 +1,354 @@
+package acl
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/clodevo/raven-proxy/pkg/database"
+	"github.com/google/uuid"
+)
+
+// Actions of exceptions.
+const (
+	ExceptionAllow = "allow"
+	ExceptionDeny  = "deny"
+)
+
+// Events recorded in the exception audit trail.
+const (
+	AuditCreated = "created"
+	AuditRevoked = "revoked"
+	AuditExpired = "expired"
+)
+
+var (
+	// ErrInvalidException is returned (wrapped) for exceptions that cannot be added as given.
+	ErrInvalidException = errors.New("invalid exception")
+	// ErrExceptionNotFound is returned when revoking an exception that does not exist or was already removed.
+	ErrExceptionNotFound = errors.New("exception not found")
+)
+
+// Exception is a temporary allow or deny rule of a tenant. Exceptions are
+// kept in the database rather than in the ACL file, so that they can be
+// added through the admin API, and stop applying at ExpiresAt. They take
+// precedence over the ACL file: deny exceptions first, then allow
+// exceptions, then the Blacklist and Whitelist.
+type Exception struct {
+	ID         string
+	TenantID   string
+	TenantName string
+	Action     string
+	Pattern    string
+	Protocols  []string
+	Reason     string
+	CreatedBy  string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+
+	rule *Rule
+}
+
+// ExceptionAudit is a record of an exception being created, revoked or expiring.
+type ExceptionAudit struct {
+	ID          string
+	ExceptionID string
+	TenantID    string
+	Event       string
+	Action      string
+	Pattern     string
+	Protocols   []string
+	Reason      string
+	Actor       string // who created or revoked the exception; empty for expiry
+	ExpiresAt   time.Time
+	At          time.Time
+}
+
+// compile checks the exception and compiles its pattern.
+func (e *Exception) compile() error {
+	if e.Action != ExceptionAllow && e.Action != ExceptionDeny {
+		return fmt.Errorf("%w: unknown action %q, expected allow or deny", ErrInvalidException, e.Action)
+	}
+	rules, err := compileEntries([]RuleEntry{{Pattern: e.Pattern, Protocols: e.Protocols}}, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidException, err)
+	}
+	e.rule = rules[0]
+	return nil
+}
+
+// String describes the exception for logs.
+func (e *Exception) String() string {
+	return fmt.Sprintf("%s exception %s for %s (%s, expires %s)", e.Action, e.ID, e.rule, e.Reason, e.ExpiresAt.Format(time.RFC3339))
+}
+
+// AddException validates e, stores it with a new ID and puts it in force.
+// ExpiresAt must be in the future and Reason must be set.
+func (a *ACLManager) AddException(e *Exception) error {
+	now := a.clock()
+	if err := e.compile(); err != nil {
+		return err
+	}
+	if strings.TrimSpace(e.Reason) == "" {
+		return fmt.Errorf("%w: a reason is required", ErrInvalidException)
+	}
+	if !e.ExpiresAt.After(now) {
+		return fmt.Errorf("%w: expiry %s is not in the future", ErrInvalidException, e.ExpiresAt.Format(time.RFC3339))
+	}
+	e.ID = uuid.NewString()
+	e.CreatedAt = now.UTC()
+	e.ExpiresAt = e.ExpiresAt.UTC()
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`
+        INSERT INTO acl_exceptions (exception_id, tenant_id, action, pattern, protocols, reason, created_by, created_at, expires_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.ID, e.TenantID, e.Action, e.Pattern, strings.Join(e.Protocols, ","), e.Reason, e.CreatedBy, e.CreatedAt, e.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	if err := audit(tx, e, AuditCreated, e.CreatedBy, now); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	a.logger.Info("Added %s for tenant %s", e, e.TenantName)
+	a.refreshExceptions()
+	return nil
+}
+
+// RevokeException removes an exception of a tenant before it expires.
+func (a *ACLManager) RevokeException(tenantID, exceptionID, actor string) error {
+	e, err := findException(tenantID, exceptionID)
+	if err != nil {
+		return err
+	}
+	removed, err := a.removeException(e, AuditRevoked, actor)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return ErrExceptionNotFound
+	}
+	a.logger.Info("Revoked exception %s of tenant %s", exceptionID, tenantID)
+	a.refreshExceptions()
+	return nil
+}
+
+// removeException deletes an exception and records why. It reports false
+// when the exception was already gone, for instance because another
+// instance removed it first.
+func (a *ACLManager) removeException(e *Exception, event, actor string) (bool, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec("DELETE FROM acl_exceptions WHERE exception_id = ?", e.ID)
+	if err != nil {
+		return false, err
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return false, err
+	}
+	if err := audit(tx, e, event, actor, a.clock()); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func audit(tx *sql.Tx, e *Exception, event, actor string, at time.Time) error {
+	_, err := tx.Exec(`
+        INSERT INTO acl_exception_audit (audit_id, exception_id, tenant_id, event, action, pattern, protocols, reason, actor, expires_at, at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		uuid.NewString(), e.ID, e.TenantID, event, e.Action, e.Pattern, strings.Join(e.Protocols, ","), e.Reason, actor, e.ExpiresAt, at.UTC())
+	return err
+}
+
+// SyncExceptions removes the exceptions that have expired, recording each
+// in the audit trail, and reloads the rest from the database. Exceptions
+// stop applying as soon as they expire even between syncs; the sync also
+// picks up exceptions added by other instances sharing the database.
+func (a *ACLManager) SyncExceptions() error {
+	a.exceptionsMu.Lock()
+	defer a.exceptionsMu.Unlock()
+
+	exceptions, err := a.loadExceptions()
+	if err != nil {
+		return err
+	}
+	now := a.clock()
+	byTenant := make(map[string][]*Exception)
+	for _, e := range exceptions {
+		if !now.Before(e.ExpiresAt) {
+			removed, err := a.removeException(e, AuditExpired, "")
+			if err != nil {
+				return err
+			}
+			if removed {
+				a.logger.Info("Removed expired %s of tenant %s", e, e.TenantName)
+			}
+			continue
+		}
+		byTenant[e.TenantName] = append(byTenant[e.TenantName], e)
+	}
+	a.exceptions.Store(&byTenant)
+	return nil
+}
+
+// refreshExceptions reloads the exceptions after a change made through
+// this instance. Failures are only logged, since the change is stored and
+// the next sync picks it up.
+func (a *ACLManager) refreshExceptions() {
+	if err := a.SyncExceptions(); err != nil {
+		a.logger.Info("Error reloading ACL exceptions: %v", err)
+	}
+}
+
+// StartExceptionSync syncs the exceptions every interval in a background
+// goroutine, after a first sync right away. A non-positive interval only
+// does the first sync.
+func (a *ACLManager) StartExceptionSync(interval time.Duration) {
+	a.refreshExceptions()
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			a.refreshExceptions()
+		}
+	}()
+}
+
+// loadExceptions reads every stored exception, ordered by creation.
+// Exceptions that no longer compile are logged and skipped, so that one
+// bad row does not keep the others from loading.
+func (a *ACLManager) loadExceptions() ([]*Exception, error) {
+	rows, err := database.DB.Query(`
+        SELECT e.exception_id, e.tenant_id, t.tenant_name, e.action, e.pattern, e.protocols, e.reason, e.created_by, e.created_at, e.expires_at
+        FROM acl_exceptions e JOIN tenants t ON t.tenant_id = e.tenant_id
+        ORDER BY e.created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var exceptions []*Exception
+	for rows.Next() {
+		e, err := scanException(rows)
+		if err != nil {
+			return nil, err
+		}
+		if err := e.compile(); err != nil {
+			// Only possible if the rule syntax changed since it was stored.
+			a.logger.Info("Skipping exception %s of tenant %s: %v", e.ID, e.TenantName, err)
+			continue
+		}
+		exceptions = append(exceptions, e)
+	}
+	return exceptions, rows.Err()
+}
+
+func findException(tenantID, exceptionID string) (*Exception, error) {
+	row := database.DB.QueryRow(`
+        SELECT e.exception_id, e.tenant_id, t.tenant_name, e.action, e.pattern, e.protocols, e.reason, e.created_by, e.created_at, e.expires_at
+        FROM acl_exceptions e JOIN tenants t ON t.tenant_id = e.tenant_id
+        WHERE e.exception_id = ? AND e.tenant_id = ?`, exceptionID, tenantID)
+	e, err := scanException(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrExceptionNotFound
+	}
+	return e, err
+}
+
+func scanException(row interface{ Scan(...any) error }) (*Exception, error) {
+	e := &Exception{}
+	var protocols string
+	if err := row.Scan(&e.ID, &e.TenantID, &e.TenantName, &e.Action, &e.Pattern, &protocols, &e.Reason, &e.CreatedBy, &e.CreatedAt, &e.ExpiresAt); err != nil {
+		return nil, err
+	}
+	if protocols != "" {
+		e.Protocols = strings.Split(protocols, ",")
+	}
+	return e, nil
+}
+
+// ExceptionsFor returns the exceptions of a tenant that are in force, in
+// the order they were added.
+func (a *ACLManager) ExceptionsFor(tenantName string) []*Exception {
+	now := a.clock()
+	var active []*Exception
+	for _, e := range (*a.exceptions.Load())[tenantName] {
+		if now.Before(e.ExpiresAt) {
+			active = append(active, e)
+		}
+	}
+	return active
+}
+
+// ExceptionAuditFor returns the audit trail of a tenant's exceptions, oldest first.
+func ExceptionAuditFor(tenantID string) ([]ExceptionAudit, error) {
+	rows, err := database.DB.Query(`
+        SELECT audit_id, exception_id, tenant_id, event, action, pattern, protocols, reason, actor, expires_at, at
+        FROM acl_exception_audit WHERE tenant_id = ? ORDER BY at`, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []ExceptionAudit
+	for rows.Next() {
+		var r ExceptionAudit
+		var protocols string
+		if err := rows.Scan(&r.ID, &r.ExceptionID, &r.TenantID, &r.Event, &r.Action, &r.Pattern, &protocols, &r.Reason, &r.Actor, &r.ExpiresAt, &r.At); err != nil {
+			return nil, err
+		}
+		if protocols != "" {
+			r.Protocols = strings.Split(protocols, ",")
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// matchException returns the first exception of the tenant with the given
+// action that covers host and port over protocol and has not expired at now.
+func (a *ACLManager) matchException(tenantName, action, host, port, protocol string, now time.Time) *Exception {
+	for _, e := range (*a.exceptions.Load())[tenantName] {
+		if e.Action == action && now.Before(e.ExpiresAt) && e.rule.appliesTo(protocol) && e.rule.matches(host, port) {
+			return e
+		}
+	}
+	return nil
+}
+
+// matchExceptionIP returns the first IP or CIDR exception of the tenant
+// with the given action that covers ip and port over protocol and has not
+// expired at now. An empty protocol matches the exceptions of every
+// protocol.
+func (a *ACLManager) matchExceptionIP(tenantName, action string, ip net.IP, port, protocol string, now time.Time) *Exception {
+	for _, e := range (*a.exceptions.Load())[tenantName] {
+		if e.Action == action && now.Before(e.ExpiresAt) && (protocol == "" || e.rule.appliesTo(protocol)) && e.rule.matchesIP(ip, port) {
+			return e
+		}
+	}
+	return nil
+}
+
+// exceptionIPs returns a matcher of the tenant's IP and CIDR exceptions
+// for its address policy.
+func (a *ACLManager) exceptionIPs(tenantName string) func(action string, ip net.IP, port, protocol string) *Exception {
+	return func(action string, ip net.IP, port, protocol string) *Exception {
+		return a.matchExceptionIP(tenantName, action, ip, port, protocol, a.clock())
+	}
+}
@@ -0,0 +1,273 @@
+package acl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Layers of a decision, in the order they are consulted.
+const (
+	LayerGlobal     = "global"
+	LayerExceptions = "exceptions"
+	LayerTenant     = "tenant"
+	LayerGroup      = "group"
+	LayerDefault    = "default"
+	// LayerAddresses is only consulted by Explain, for destinations that
+	// are IP addresses; names are checked once resolved, when connecting.
+	LayerAddresses = "addresses"
+)
+
+// Step is a list consulted while deciding on a destination. Rule is the
+// rule of the list that matched, or nil when none did; a step with a rule
+// decides, except in the default layer, which decides without one.
+type Step struct {
+	Layer   string
+	Group   string // the group of the list, in the group layer
+	List    string // Blacklist, Overrides or Whitelist, or deny or allow for exceptions
+	Allowed bool   // whether the list allows or blocks what it matches
+	Rule    *Rule
+	// Block and Exception are the global block and exception Rule belongs
+	// to, in the global and exceptions layers.
+	Block     *GlobalBlock
+	Exception *Exception
+	// Detail explains the decision of the addresses layer.
+	Detail string
+}
+
+// Explanation is how a destination is decided for a tenant: every step
+// consulted, in order, ending with the one that decided.
+type Explanation struct {
+	Tenant   string
+	Host     string
+	Port     string
+	Protocol string
+	Allowed  bool
+	Decision Step
+	Steps    []Step
+}
+
+// decide evaluates host and port over protocol for a tenant with the given
+// policy, which may be nil, through every layer in order, and returns the
+// step that decided. trace, when not nil, is called with every step
+// consulted, including the deciding one.
+func (a *ACLManager) decide(tenantName string, policy *Policy, host, port, protocol string, now time.Time, trace func(Step)) Step {
+	consult := func(step Step) bool {
+		if trace != nil {
+			trace(step)
+		}
+		return step.Rule != nil
+	}
+
+	rule, block := a.matchGlobal(host, port, protocol, now)
+	if step := (Step{Layer: LayerGlobal, List: ListBlacklist, Rule: rule, Block: block}); consult(step) {
+		return step
+	}
+	for _, action := range []string{ExceptionDeny, ExceptionAllow} {
+		step := Step{Layer: LayerExceptions, List: action, Allowed: action == ExceptionAllow}
+		if step.Exception = a.matchException(tenantName, action, host, port, protocol, now); step.Exception != nil {
+			step.Rule = step.Exception.rule
+		}
+		if consult(step) {
+			return step
+		}
+	}
+	if policy != nil {
+		if step, decided := policy.evaluate(host, port, protocol, now, consult); decided {
+			return step
+		}
+	}
+	step := Step{Layer: LayerDefault}
+	consult(step)
+	return step
+}
+
+// ProtocolForMethod returns the protocol requests with the given HTTP
+// method and URI scheme are evaluated as, and the port they default to:
+// 443 for CONNECT, and otherwise that of the scheme, as for requests
+// without a port in their Host.
+func ProtocolForMethod(method, scheme string) (protocol, defaultPort string) {
+	if strings.EqualFold(method, http.MethodConnect) {
+		return ProtocolConnect, "443"
+	}
+	if strings.EqualFold(scheme, "https") {
+		return ProtocolHTTP, "443"
+	}
+	return ProtocolHTTP, "80"
+}
+
+// Explain decides on a destination for a tenant the way IsRequestAllowed
+// does, recording every step. The port defaults to the one in host, if
+// any, then to 443 for CONNECT, and otherwise to that of the URI scheme,
+// 443 for https and 80 for http or no scheme.
+// candidates, when not nil, replace the loaded policies of the tenants
+// they hold, so that ACL changes can be tested before they are deployed
+// (see CompileList). Destinations that are IP addresses are also checked
+// against the tenant's address policy.
+func (a *ACLManager) Explain(tenantName, host, port, method, scheme string, candidates map[string]*Policy) *Explanation {
+	protocol, defaultPort := ProtocolForMethod(method, scheme)
+	if hostOnly, hostPort, err := net.SplitHostPort(host); err == nil {
+		host = hostOnly
+		if port == "" {
+			port = hostPort
+		}
+	}
+	if port == "" {
+		port = defaultPort
+	}
+	policy, exists := candidates[tenantName]
+	if !exists {
+		policy = a.Policy(tenantName)
+	}
+
+	e := &Explanation{Tenant: tenantName, Host: host, Port: port, Protocol: protocol}
+	e.Decision = a.decide(tenantName, policy, host, port, protocol, a.clock(), func(step Step) {
+		e.Steps = append(e.Steps, step)
+	})
+
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil && e.Decision.Allowed {
+		step := Step{Layer: LayerAddresses, Allowed: true, Detail: "address allowed"}
+		if err := a.addressesOf(tenantName, policy, protocol).Check(ip, port); err != nil {
+			step.Allowed, step.Detail = false, err.Error()
+		}
+		e.Steps = append(e.Steps, step)
+		e.Decision = step
+	}
+	e.Allowed = e.Decision.Allowed
+	return e
+}
+
+// errOutsideRoot is returned for files of candidate lists that lie
+// outside the directory candidates may read from.
+var errOutsideRoot = errors.New("outside the ACL directory")
+
+// SetCandidateRoot sets the directory that the imported lists and CA files
+// of candidate lists must lie within, which is the ACL data path by
+// default. It must be called before the manager is used.
+func (a *ACLManager) SetCandidateRoot(root string) {
+	a.candidateRoot = root
+}
+
+// CompileList compiles the content of an ACL file for a tenant without
+// loading it, against the current policy groups, for Explain. Relative
+// paths are resolved against the ACL data path. Since the content comes
+// from the admin API rather than from the ACL files, the files it refers
+// to must lie within the candidate root (see SetCandidateRoot).
+func (a *ACLManager) CompileList(tenantName string, content []byte) (*Policy, error) {
+	list := &List{}
+	if err := json.Unmarshal(content, list); err != nil {
+		return nil, fmt.Errorf("parsing list file: %w", err)
+	}
+	if err := a.confineCandidate(list); err != nil {
+		return nil, err
+	}
+
+	a.reloadMu.Lock()
+	groups := a.groups
+	a.reloadMu.Unlock()
+
+	policy, err := resolvePolicy(tenantName, list, a.aclDataPath, groups)
+	if err != nil {
+		return nil, err
+	}
+	policy.Addresses.bind(a, tenantName)
+	return policy, nil
+}
+
+// confineCandidate checks that the files a candidate list refers to lie
+// within the candidate root, so that the admin API cannot be used to read
+// arbitrary files of the proxy host. Errors name the files as the list
+// does.
+func (a *ACLManager) confineCandidate(list *List) error {
+	paths := make([]string, 0, len(list.Imports)+2)
+	for _, entry := range list.Imports {
+		paths = append(paths, entry.Path)
+	}
+	if list.Intercept != nil {
+		paths = append(paths, list.Intercept.CACert, list.Intercept.CAKey)
+	}
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if err := confined(path, a.aclDataPath, a.candidateRoot); err != nil {
+			var pathErr *fs.PathError
+			if errors.As(err, &pathErr) {
+				err = pathErr.Err
+			}
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// confined resolves path against baseDir and checks that it lies within
+// root, both as written and once symbolic links are followed. Paths
+// outside root are refused before they are looked up, so that errors do
+// not tell whether they exist.
+func confined(path, baseDir, root string) error {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(baseDir, path)
+	}
+	if !within(root, path) {
+		return errOutsideRoot
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	if !within(resolvedRoot, resolved) {
+		return errOutsideRoot
+	}
+	return nil
+}
+
+// within reports whether path is root or lies below it.
+func within(root, path string) bool {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return false
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absRoot, absPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Matcher describes how the rule was compiled to match hosts: as a
+// network, a literal name or suffix, or a regular expression, and the
+// ports it covers.
+func (r *Rule) Matcher() string {
+	var matcher string
+	switch {
+	case r.Network != nil:
+		matcher = "network " + r.Network.String()
+	case r.literal == "*":
+		matcher = "any host"
+	case strings.HasPrefix(r.literal, "*"):
+		matcher = "host suffix " + strings.TrimPrefix(r.literal, "*")
+	case r.literal != "":
+		matcher = "exact host " + r.literal
+	case r.Regex != nil:
+		matcher = "regexp " + r.Regex.String()
+	}
+	if r.portLow != 0 {
+		if r.portLow == r.portHigh {
+			return fmt.Sprintf("%s, port %d", matcher, r.portLow)
+		}
+		return fmt.Sprintf("%s, ports %d-%d", matcher, r.portLow, r.portHigh)
+	}
+	return matcher
+}
@@ -0,0 +1,52 @@
+package acl
+
+// ForwardingList is the "Forwarding" section of a tenant ACL file. It
+// controls the headers the proxy adds to the requests it forwards.
+//
+//	"Forwarding": {
+//	  "Via": true,
+//	  "XForwardedFor": true,
+//	  "Forwarded": false
+//	}
+type ForwardingList struct {
+	// Via adds the proxy to the Via header. It defaults to true.
+	Via *bool `json:"Via"`
+	// XForwardedFor appends the client address to X-Forwarded-For.
+	XForwardedFor bool `json:"XForwardedFor"`
+	// Forwarded appends the client address and protocol to Forwarded (RFC 7239).
+	Forwarded bool `json:"Forwarded"`
+}
+
+// ForwardingPolicy is the compiled form of a ForwardingList.
+type ForwardingPolicy struct {
+	Via           bool
+	XForwardedFor bool
+	Forwarded     bool
+}
+
+// defaultForwarding applies to tenants without a Forwarding section.
+var defaultForwarding = &ForwardingPolicy{Via: true}
+
+func compileForwarding(list *ForwardingList) *ForwardingPolicy {
+	if list == nil {
+		return defaultForwarding
+	}
+	policy := &ForwardingPolicy{
+		Via:           true,
+		XForwardedFor: list.XForwardedFor,
+		Forwarded:     list.Forwarded,
+	}
+	if list.Via != nil {
+		policy.Via = *list.Via
+	}
+	return policy
+}
+
+// ForwardingFor returns the forwarding settings of a tenant.
+func (a *ACLManager) ForwardingFor(tenantName string) *ForwardingPolicy {
+	policy := a.Policy(tenantName)
+	if policy == nil {
+		return defaultForwarding
+	}
+	return policy.Forwarding
+}
@@ -0,0 +1,359 @@
+package acl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/clodevo/raven-proxy/pkg/database"
+	"github.com/google/uuid"
+)
+
+const (
+	// globalDir is the subdirectory of the ACL data path that holds the
+	// global blocklist file.
+	globalDir = "global"
+	// globalFile is the name of the global blocklist file in globalDir.
+	globalFile = "blocklist.json"
+	// globalBlockTag marks the log lines of requests the global blocklist
+	// blocks, so that they can be told apart from tenant decisions.
+	globalBlockTag = "[GLOBAL-BLOCK]"
+)
+
+// Sources of global blocklist entries.
+const (
+	GlobalSourceAPI  = "api"
+	GlobalSourceFile = "file"
+)
+
+var (
+	// ErrInvalidGlobalBlock is returned (wrapped) for global blocks that cannot be added as given.
+	ErrInvalidGlobalBlock = errors.New("invalid global block")
+	// ErrGlobalBlockNotFound is returned when removing a global block that does not exist or was already removed.
+	ErrGlobalBlockNotFound = errors.New("global block not found")
+)
+
+// GlobalList is the on-disk format of the global blocklist file
+// (global/blocklist.json). It only has a Blacklist and lists to import
+// into it; there is nothing a global file could allow.
+type GlobalList struct {
+	Blacklist []RuleEntry   `json:"Blacklist"`
+	Imports   []ImportEntry `json:"Imports,omitempty"`
+}
+
+// GlobalBlock is an entry of the global blocklist. Entries added through
+// the admin API are kept in the database, so that every proxy instance
+// sharing it picks them up; entries of the global file have no ID.
+type GlobalBlock struct {
+	ID        string
+	Source    string
+	Pattern   string
+	Protocols []string
+	Reason    string
+	CreatedBy string
+	CreatedAt time.Time
+
+	rule *Rule
+}
+
+// globalBlocklist is a snapshot of the global blocklist, combining the
+// global file and the entries added through the admin API.
+type globalBlocklist struct {
+	file *Policy // nil without a global file
+	// fileEntries is the number of rules of file that come from its own
+	// Blacklist, which precede the imported ones.
+	fileEntries int
+
+	blocks []*GlobalBlock
+	rules  *ruleSet // indexes blocks
+	// addresses are the IP and CIDR rules of the file and of blocks, which
+	// also apply to the addresses names resolve to.
+	addresses []*Rule
+}
+
+// globalPath returns the path of the global blocklist file.
+func (a *ACLManager) globalPath() string {
+	return filepath.Join(a.aclDataPath, globalDir, globalFile)
+}
+
+// reloadGlobal reloads the global blocklist file if it changed, keeping
+// the last good version when it fails to load. a.reloadMu must be held.
+func (a *ACLManager) reloadGlobal() {
+	previous := a.global.Load().file
+
+	info, err := os.Stat(a.globalPath())
+	if err != nil {
+		if previous != nil {
+			a.logger.Info("Removed global blocklist file %s", a.globalPath())
+			a.updateGlobal(func(next *globalBlocklist) {
+				next.file = nil
+				next.fileEntries = 0
+			})
+		}
+		return
+	}
+	if previous != nil && previous.modTime.Equal(info.ModTime()) && previous.size == info.Size() && !previous.sourcesChanged() {
+		return
+	}
+
+	file, entries, err := loadGlobal(a.globalPath(), info)
+	if err != nil {
+		a.logger.Info("Error loading global blocklist file, keeping last good version: %v", err)
+		return
+	}
+	a.updateGlobal(func(next *globalBlocklist) {
+		next.file = file
+		next.fileEntries = entries
+	})
+	a.logger.Info("Loaded global blocklist file with %d entries", len(file.Blacklist))
+	a.logImports(file.Imports, "the global blocklist")
+}
+
+// loadGlobal reads and compiles the global blocklist file. Sections other
+// than Blacklist and Imports are rejected rather than ignored, since a
+// Whitelist there would suggest it can allow something. It also returns
+// the number of entries of the file's own Blacklist.
+func loadGlobal(filePath string, info os.FileInfo) (*Policy, int, error) {
+	fileContent, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading list file: %w", err)
+	}
+	var global GlobalList
+	decoder := json.NewDecoder(bytes.NewReader(fileContent))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&global); err != nil {
+		return nil, 0, fmt.Errorf("parsing list file: %w", err)
+	}
+	for _, entry := range global.Imports {
+		if entry.Into != "" && entry.Into != IntoBlacklist {
+			return nil, 0, fmt.Errorf("%s: the global blocklist can only import into the Blacklist", entry.Path)
+		}
+	}
+
+	list := &List{Blacklist: global.Blacklist, Imports: global.Imports}
+	file, err := compilePolicy("global", list, filepath.Dir(filePath), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	file.modTime = info.ModTime()
+	file.size = info.Size()
+	return file, len(global.Blacklist), nil
+}
+
+// updateGlobal publishes a new snapshot of the global blocklist, made by
+// applying update to a copy of the current one.
+func (a *ACLManager) updateGlobal(update func(next *globalBlocklist)) {
+	a.globalMu.Lock()
+	defer a.globalMu.Unlock()
+
+	next := *a.global.Load()
+	update(&next)
+	next.addresses = nil
+	if next.file != nil {
+		for _, rule := range next.file.Blacklist {
+			if rule.Network != nil {
+				next.addresses = append(next.addresses, rule)
+			}
+		}
+	}
+	for _, b := range next.blocks {
+		if b.rule.Network != nil {
+			next.addresses = append(next.addresses, b.rule)
+		}
+	}
+	a.global.Store(&next)
+}
+
+// compile checks the block and compiles its pattern.
+func (b *GlobalBlock) compile() error {
+	rules, err := compileEntries([]RuleEntry{{Pattern: b.Pattern, Protocols: b.Protocols}}, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidGlobalBlock, err)
+	}
+	b.rule = rules[0]
+	return nil
+}
+
+// AddGlobalBlock validates b, stores it with a new ID and blocks it for
+// every tenant. Reason must be set.
+func (a *ACLManager) AddGlobalBlock(b *GlobalBlock) error {
+	if err := b.compile(); err != nil {
+		return err
+	}
+	if strings.TrimSpace(b.Reason) == "" {
+		return fmt.Errorf("%w: a reason is required", ErrInvalidGlobalBlock)
+	}
+	b.ID = uuid.NewString()
+	b.Source = GlobalSourceAPI
+	b.CreatedAt = a.clock().UTC()
+
+	_, err := database.DB.Exec(`
+        INSERT INTO acl_global_blocks (block_id, pattern, protocols, reason, created_by, created_at)
+        VALUES (?, ?, ?, ?, ?, ?)`,
+		b.ID, b.Pattern, strings.Join(b.Protocols, ","), b.Reason, b.CreatedBy, b.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	a.logger.Info("%s Added global block %s for %s by %s: %s", globalBlockTag, b.ID, b.rule, b.CreatedBy, b.Reason)
+	a.refreshGlobalBlocks()
+	return nil
+}
+
+// RemoveGlobalBlock removes a global block added through the admin API.
+func (a *ACLManager) RemoveGlobalBlock(blockID, actor string) error {
+	result, err := database.DB.Exec("DELETE FROM acl_global_blocks WHERE block_id = ?", blockID)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return ErrGlobalBlockNotFound
+	}
+
+	a.logger.Info("%s Removed global block %s by %s", globalBlockTag, blockID, actor)
+	a.refreshGlobalBlocks()
+	return nil
+}
+
+// SyncGlobalBlocks reloads the global blocks from the database, picking up
+// the blocks added and removed through other instances sharing it. Blocks
+// that no longer compile are logged and skipped.
+func (a *ACLManager) SyncGlobalBlocks() error {
+	a.globalSyncMu.Lock()
+	defer a.globalSyncMu.Unlock()
+
+	rows, err := database.DB.Query(`
+        SELECT block_id, pattern, protocols, reason, created_by, created_at
+        FROM acl_global_blocks ORDER BY created_at`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	blocks := []*GlobalBlock{}
+	for rows.Next() {
+		b := &GlobalBlock{Source: GlobalSourceAPI}
+		var protocols string
+		if err := rows.Scan(&b.ID, &b.Pattern, &protocols, &b.Reason, &b.CreatedBy, &b.CreatedAt); err != nil {
+			return err
+		}
+		if protocols != "" {
+			b.Protocols = strings.Split(protocols, ",")
+		}
+		if err := b.compile(); err != nil {
+			// Only possible if the rule syntax changed since it was stored.
+			a.logger.Info("%s Skipping global block %s: %v", globalBlockTag, b.ID, err)
+			continue
+		}
+		blocks = append(blocks, b)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if !sameBlocks(a.global.Load().blocks, blocks) {
+		rules := make([]*Rule, len(blocks))
+		for i, b := range blocks {
+			rules[i] = b.rule
+		}
+		a.updateGlobal(func(next *globalBlocklist) {
+			next.blocks = blocks
+			next.rules = newRuleSet(rules)
+		})
+		a.logger.Debug("Loaded %d global blocks", len(blocks))
+	}
+	return nil
+}
+
+// sameBlocks reports whether two lists hold the same blocks in the same
+// order, in which case the snapshot does not need to be rebuilt.
+func sameBlocks(current, next []*GlobalBlock) bool {
+	if len(current) != len(next) {
+		return false
+	}
+	for i := range current {
+		if current[i].ID != next[i].ID {
+			return false
+		}
+	}
+	return true
+}
+
+// refreshGlobalBlocks reloads the global blocks after a change made
+// through this instance. Failures are only logged, since the change is
+// stored and the next sync picks it up.
+func (a *ACLManager) refreshGlobalBlocks() {
+	if err := a.SyncGlobalBlocks(); err != nil {
+		a.logger.Info("Error reloading global blocks: %v", err)
+	}
+}
+
+// StartGlobalBlockSync syncs the global blocks every interval in a
+// background goroutine, after a first sync right away. A non-positive
+// interval only does the first sync.
+func (a *ACLManager) StartGlobalBlockSync(interval time.Duration) {
+	a.refreshGlobalBlocks()
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			a.refreshGlobalBlocks()
+		}
+	}()
+}
+
+// GlobalBlocks returns the entries of the global blocklist: the Blacklist
+// of the global file, without imported lists, then the blocks added
+// through the admin API in the order they were added.
+func (a *ACLManager) GlobalBlocks() []*GlobalBlock {
+	global := a.global.Load()
+	var blocks []*GlobalBlock
+	if global.file != nil {
+		for _, rule := range global.file.Blacklist[:global.fileEntries] {
+			blocks = append(blocks, &GlobalBlock{Source: GlobalSourceFile, Pattern: rule.Pattern, Protocols: rule.Protocols, rule: rule})
+		}
+	}
+	return append(blocks, global.blocks...)
+}
+
+// matchGlobal returns the global blocklist rule that covers host and port
+// over protocol, and the block it belongs to when it was added through
+// the admin API.
+func (a *ACLManager) matchGlobal(host, port, protocol string, now time.Time) (*Rule, *GlobalBlock) {
+	global := a.global.Load()
+	if global.file != nil {
+		if rule := global.file.blacklist.match(host, port, protocol, now); rule != nil {
+			return rule, nil
+		}
+	}
+	if rule := global.rules.match(host, port, protocol, now); rule != nil {
+		for _, b := range global.blocks {
+			if b.rule == rule {
+				return rule, b
+			}
+		}
+	}
+	return nil, nil
+}
+
+// matchGlobalIP returns the IP or CIDR rule of the global blocklist that
+// covers ip and port over protocol, or nil. An empty protocol matches the
+// rules of every protocol.
+func (a *ACLManager) matchGlobalIP(ip net.IP, port, protocol string) *Rule {
+	for _, rule := range a.global.Load().addresses {
+		if (protocol == "" || rule.appliesTo(protocol)) && rule.matchesIP(ip, port) {
+			return rule
+		}
+	}
+	return nil
+}
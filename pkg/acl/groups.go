@@ -0,0 +1,201 @@
+package acl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// groupsDir is the subdirectory of the ACL data path that holds policy
+// group files (<group>.json), which tenants name in their Groups section.
+const groupsDir = "groups"
+
+// Lists of a policy that a decision can come from.
+const (
+	ListBlacklist = "Blacklist"
+	ListOverrides = "Overrides"
+	ListWhitelist = "Whitelist"
+)
+
+// groupsPath returns the directory of the policy group files.
+func (a *ACLManager) groupsPath() string {
+	return filepath.Join(a.aclDataPath, groupsDir)
+}
+
+// reloadGroups rescans the group files, reusing the groups whose files have
+// not changed and keeping the last good version of those that fail to
+// load. a.reloadMu must be held.
+func (a *ACLManager) reloadGroups() map[string]*Policy {
+	files, err := filepath.Glob(filepath.Join(a.groupsPath(), "*.json"))
+	if err != nil {
+		a.logger.Info("Error listing group files in %s: %v", a.groupsPath(), err)
+		return a.groups
+	}
+
+	next := make(map[string]*Policy, len(files))
+	for _, filePath := range files {
+		name := strings.TrimSuffix(filepath.Base(filePath), ".json")
+		previous := a.groups[name]
+
+		info, err := os.Stat(filePath)
+		if err != nil {
+			a.logger.Debug("Error reading group file %s: %v", name, err)
+			continue
+		}
+		if previous != nil && previous.modTime.Equal(info.ModTime()) && previous.size == info.Size() && !previous.sourcesChanged() {
+			next[name] = previous
+			continue
+		}
+
+		group, err := loadGroup(name, filePath, info)
+		if err != nil {
+			a.logger.Info("Error loading group file %s, keeping last good version: %v", name, err)
+			if previous != nil {
+				next[name] = previous
+			}
+			continue
+		}
+		next[name] = group
+		a.logger.Debug("Loaded policy group: %s", name)
+		a.logImports(group.Imports, "group "+name)
+	}
+
+	for name := range a.groups {
+		if _, exists := next[name]; !exists {
+			a.logger.Debug("Removed policy group: %s", name)
+		}
+	}
+	a.groups = next
+	return next
+}
+
+// loadGroup reads and compiles a group file. Groups cannot belong to other
+// groups, and overrides only make sense for tenants.
+func loadGroup(name, filePath string, info os.FileInfo) (*Policy, error) {
+	list, err := readList(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(list.Groups) > 0 {
+		return nil, fmt.Errorf("groups cannot include other groups")
+	}
+	if len(list.Overrides) > 0 {
+		return nil, fmt.Errorf("overrides are only allowed in tenant files")
+	}
+	// Tenants may inherit the CA files, so make their paths independent of
+	// the tenant file's directory.
+	baseDir := filepath.Dir(filePath)
+	if list.Intercept != nil {
+		intercept := *list.Intercept
+		for _, path := range []*string{&intercept.CACert, &intercept.CAKey} {
+			if *path != "" && !filepath.IsAbs(*path) {
+				*path = filepath.Join(baseDir, *path)
+			}
+		}
+		list.Intercept = &intercept
+	}
+
+	group, err := compilePolicy(name, list, baseDir, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range group.Whitelist {
+		rule.Group = name
+	}
+	for _, rule := range group.Blacklist {
+		rule.Group = name
+	}
+	for i := range group.Imports {
+		group.Imports[i].Group = name
+	}
+	group.list = list
+	group.modTime = info.ModTime()
+	group.size = info.Size()
+	return group, nil
+}
+
+// inheritSections fills the sections other than the rule lists that the
+// tenant list leaves out from its groups: each comes from the first group,
+// in the order of Groups, that has it.
+func inheritSections(list *List, groups []*Policy) {
+	for _, group := range groups {
+		g := group.list
+		if list.Upstream == nil {
+			list.Upstream = g.Upstream
+		}
+		if list.Intercept == nil {
+			list.Intercept = g.Intercept
+		}
+		if list.Tunnel == nil {
+			list.Tunnel = g.Tunnel
+		}
+		if list.Forwarding == nil {
+			list.Forwarding = g.Forwarding
+		}
+		if list.RateLimit == nil {
+			list.RateLimit = g.RateLimit
+		}
+		if list.Bandwidth == nil {
+			list.Bandwidth = g.Bandwidth
+		}
+		if list.Quota == nil {
+			list.Quota = g.Quota
+		}
+		if list.Scheduling == nil {
+			list.Scheduling = g.Scheduling
+		}
+		if list.Addresses == nil {
+			list.Addresses = g.Addresses
+		}
+	}
+}
+
+// sameGroups reports whether the policy was compiled against the current
+// versions of its groups.
+func (p *Policy) sameGroups(groups map[string]*Policy) bool {
+	for i, name := range p.Groups {
+		if groups[name] != p.groups[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// evaluate walks the lists of the policy that decide whether host and
+// port over protocol are allowed at now, passing each step to consult
+// until consult reports that the step decides. It returns that step, or
+// false when no list matched and the destination is blocked by default.
+// The order is:
+//
+//  1. the tenant's Blacklist blocks,
+//  2. the tenant's Overrides allow,
+//  3. the groups' Blacklists block,
+//  4. the tenant's Whitelist, then the groups' Whitelists, allow.
+func (p *Policy) evaluate(host, port, protocol string, now time.Time, consult func(Step) bool) (Step, bool) {
+	check := func(set *ruleSet, layer, group, list string, allowed bool) (Step, bool) {
+		step := Step{Layer: layer, Group: group, List: list, Allowed: allowed, Rule: set.match(host, port, protocol, now)}
+		return step, consult(step)
+	}
+	if step, decided := check(p.blacklist, LayerTenant, "", ListBlacklist, false); decided {
+		return step, true
+	}
+	if step, decided := check(p.overrides, LayerTenant, "", ListOverrides, true); decided {
+		return step, true
+	}
+	for _, group := range p.groups {
+		if step, decided := check(group.blacklist, LayerGroup, group.Tenant, ListBlacklist, false); decided {
+			return step, true
+		}
+	}
+	if step, decided := check(p.whitelist, LayerTenant, "", ListWhitelist, true); decided {
+		return step, true
+	}
+	for _, group := range p.groups {
+		if step, decided := check(group.whitelist, LayerGroup, group.Tenant, ListWhitelist, true); decided {
+			return step, true
+		}
+	}
+	return Step{}, false
+}
@@ -0,0 +1,272 @@
+package acl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Formats of imported lists.
+const (
+	FormatHosts   = "hosts"   // hosts file: an address followed by host names
+	FormatDomains = "domains" // one domain per line
+	FormatAdblock = "adblock" // Adblock-style ||domain^ rules
+)
+
+// Lists an import can be added to.
+const (
+	IntoBlacklist = "Blacklist"
+	IntoWhitelist = "Whitelist"
+)
+
+// maxImportErrors is how many invalid lines of a list are reported in full.
+const maxImportErrors = 10
+
+// errUnsupported is returned by line parsers for valid lines that do not
+// translate into host rules.
+var errUnsupported = errors.New("unsupported rule")
+
+// ImportEntry is an entry of the "Imports" section of a tenant ACL file. It
+// adds the domains of an external list file to the Blacklist (the default)
+// or the Whitelist. Relative paths are resolved against the directory of
+// the ACL file.
+//
+//	"Imports": [
+//	  {"Path": "lists/malware.hosts", "Format": "hosts"},
+//	  {"Path": "lists/ads.txt", "Format": "adblock", "Protocols": ["http"]},
+//	  {"Path": "lists/partners.txt", "Format": "domains", "Into": "Whitelist", "Subdomains": true}
+//	]
+type ImportEntry struct {
+	Path      string   `json:"Path"`
+	Format    string   `json:"Format"`
+	Into      string   `json:"Into,omitempty"`
+	Protocols []string `json:"Protocols,omitempty"`
+	Schedule  string   `json:"Schedule,omitempty"`
+	// Subdomains extends each domain of a hosts or domains list to its
+	// subdomains. Adblock rules always cover them.
+	Subdomains bool `json:"Subdomains,omitempty"`
+}
+
+// ImportReport is the outcome of loading an imported list.
+type ImportReport struct {
+	Path     string // resolved against the ACL file's directory
+	Group    string // the group that imports the list; empty for the tenant's own imports
+	Format   string
+	Into     string
+	Entries  int      // domains imported
+	Skipped  int      // valid lines that cannot be imported, such as Adblock element hiding rules
+	Invalid  int      // lines that could not be parsed
+	Errors   []string // the first invalid lines, with their line number
+	LoadedAt time.Time
+}
+
+// fileStamp identifies a version of a file that a policy was loaded from.
+type fileStamp struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// changed reports whether the file no longer is the version stamped.
+func (s fileStamp) changed() bool {
+	info, err := os.Stat(s.path)
+	return err != nil || !info.ModTime().Equal(s.modTime) || info.Size() != s.size
+}
+
+// hostsIgnored are names that hosts files map to local addresses for the
+// system's own sake rather than to block them.
+var hostsIgnored = map[string]bool{
+	"localhost": true, "localhost.localdomain": true, "local": true, "broadcasthost": true,
+	"ip6-localhost": true, "ip6-loopback": true, "ip6-localnet": true, "ip6-mcastprefix": true,
+	"ip6-allnodes": true, "ip6-allrouters": true, "ip6-allhosts": true, "0.0.0.0": true,
+}
+
+// loadImport reads an imported list and returns its entries, ready to be
+// compiled into the list it goes into.
+func loadImport(entry ImportEntry, baseDir string) ([]RuleEntry, ImportReport, fileStamp, error) {
+	report := ImportReport{Format: entry.Format, Into: entry.Into, LoadedAt: time.Now()}
+	if report.Into == "" {
+		report.Into = IntoBlacklist
+	}
+	if report.Into != IntoBlacklist && report.Into != IntoWhitelist {
+		return nil, report, fileStamp{}, fmt.Errorf("%s: unknown list %q, expected Blacklist or Whitelist", entry.Path, entry.Into)
+	}
+	var parse func(line string) ([]string, error)
+	switch entry.Format {
+	case FormatHosts:
+		parse = parseHostsLine
+	case FormatDomains:
+		parse = parseDomainsLine
+	case FormatAdblock:
+		parse = parseAdblockLine
+	default:
+		return nil, report, fileStamp{}, fmt.Errorf("%s: unknown format %q, expected hosts, domains or adblock", entry.Path, entry.Format)
+	}
+	for _, protocol := range entry.Protocols {
+		if protocol != ProtocolConnect && protocol != ProtocolHTTP {
+			return nil, report, fileStamp{}, fmt.Errorf("%s: unknown protocol %q, expected connect or http", entry.Path, protocol)
+		}
+	}
+
+	report.Path = entry.Path
+	if !filepath.IsAbs(report.Path) {
+		report.Path = filepath.Join(baseDir, report.Path)
+	}
+	file, err := os.Open(report.Path)
+	if err != nil {
+		return nil, report, fileStamp{}, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, report, fileStamp{}, err
+	}
+	stamp := fileStamp{path: report.Path, modTime: info.ModTime(), size: info.Size()}
+
+	subdomains := entry.Subdomains || entry.Format == FormatAdblock
+	seen := make(map[string]bool)
+	var entries []RuleEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		domains, err := parse(line)
+		if errors.Is(err, errUnsupported) {
+			report.Skipped++
+			continue
+		}
+		if err != nil {
+			report.Invalid++
+			if len(report.Errors) < maxImportErrors {
+				report.Errors = append(report.Errors, fmt.Sprintf("line %d: %v", lineNumber, err))
+			}
+			continue
+		}
+		for _, domain := range domains {
+			if seen[domain] {
+				continue
+			}
+			seen[domain] = true
+			report.Entries++
+			entries = append(entries, RuleEntry{Pattern: domain, Protocols: entry.Protocols, Schedule: entry.Schedule})
+			if subdomains {
+				entries = append(entries, RuleEntry{Pattern: "*." + domain, Protocols: entry.Protocols, Schedule: entry.Schedule})
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, report, stamp, err
+	}
+	return entries, report, stamp, nil
+}
+
+// parseHostsLine parses "address name..." lines. Comments and the names
+// of the local host are ignored.
+func parseHostsLine(line string) ([]string, error) {
+	line, _, _ = strings.Cut(line, "#")
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	if net.ParseIP(fields[0]) == nil {
+		return nil, fmt.Errorf("invalid address %q", fields[0])
+	}
+	if len(fields) == 1 {
+		return nil, fmt.Errorf("no host name after %s", fields[0])
+	}
+	var domains []string
+	for _, name := range fields[1:] {
+		domain, ok := normalizeDomain(name)
+		if !ok {
+			return nil, fmt.Errorf("invalid host name %q", name)
+		}
+		if !hostsIgnored[domain] {
+			domains = append(domains, domain)
+		}
+	}
+	return domains, nil
+}
+
+// parseDomainsLine parses a line holding a single domain. Lines starting
+// with ! are comments, and so is anything after a #.
+func parseDomainsLine(line string) ([]string, error) {
+	if strings.HasPrefix(line, "!") {
+		return nil, nil
+	}
+	line, _, _ = strings.Cut(line, "#")
+	if line = strings.TrimSpace(line); line == "" {
+		return nil, nil
+	}
+	domain, ok := normalizeDomain(line)
+	if !ok {
+		return nil, fmt.Errorf("invalid domain %q", line)
+	}
+	return []string{domain}, nil
+}
+
+// parseAdblockLine parses ||domain^ rules, which block a domain and its
+// subdomains. Comments and headers are ignored. The other kinds of Adblock
+// rules (exceptions, paths, element hiding, rules with options) do not
+// translate into host rules and are reported as unsupported.
+func parseAdblockLine(line string) ([]string, error) {
+	if strings.HasPrefix(line, "!") || strings.HasPrefix(line, "[") {
+		return nil, nil
+	}
+	rest, isDomainRule := strings.CutPrefix(line, "||")
+	if !isDomainRule {
+		return nil, errUnsupported
+	}
+	name, tail, terminated := strings.Cut(rest, "^")
+	if !terminated || (tail != "" && tail != "|" && tail != "$important") || strings.ContainsAny(name, "/*:?=") {
+		return nil, errUnsupported
+	}
+	domain, ok := normalizeDomain(name)
+	if !ok {
+		return nil, fmt.Errorf("invalid domain %q", name)
+	}
+	return []string{domain}, nil
+}
+
+// normalizeDomain lower-cases a host name and drops its trailing dot. It
+// reports false unless the name is made of non-empty labels of letters,
+// digits, hyphens and underscores.
+func normalizeDomain(name string) (string, bool) {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	if name == "" || len(name) > 253 {
+		return "", false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return "", false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return "", false
+			}
+		}
+	}
+	return name, true
+}
+
+// ImportsFor returns the reports of the lists imported by a tenant, in the
+// order of its Imports section, followed by those of its groups, or nil if
+// the tenant has no ACL file.
+func (a *ACLManager) ImportsFor(tenantName string) []ImportReport {
+	policy := a.Policy(tenantName)
+	if policy == nil {
+		return nil
+	}
+	reports := append([]ImportReport(nil), policy.Imports...)
+	for _, group := range policy.groups {
+		reports = append(reports, group.Imports...)
+	}
+	return reports
+}
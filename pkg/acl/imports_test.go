@@ -0,0 +1,60 @@
+package acl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/clodevo/raven-proxy/pkg/utils"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestImportPaths loads lists kept next to the tenants directory of a
+// Git repository, as the ACL data path usually is, and from an absolute
+// path elsewhere.
+func TestImportPaths(t *testing.T) {
+	repo := t.TempDir()
+	elsewhere := t.TempDir()
+	writeFile(t, filepath.Join(repo, "lists", "ads.txt"), "ads.example.com\ntracker.example.net\n")
+	writeFile(t, filepath.Join(elsewhere, "malware.hosts"), "0.0.0.0 malware.example.org\n")
+	writeFile(t, filepath.Join(repo, "tenants", "acme.json"), `{
+		"Imports": [
+			{"Path": "../lists/ads.txt", "Format": "domains"},
+			{"Path": "`+filepath.Join(elsewhere, "malware.hosts")+`", "Format": "hosts"}
+		]
+	}`)
+
+	a := NewACLManager(filepath.Join(repo, "tenants"), utils.GetLogger())
+	policy := a.Policy("acme")
+	if policy == nil {
+		t.Fatal("tenant acme was not loaded")
+	}
+
+	want := []struct {
+		path    string
+		entries int
+	}{
+		{filepath.Join(repo, "lists", "ads.txt"), 2},
+		{filepath.Join(elsewhere, "malware.hosts"), 1},
+	}
+	if len(policy.Imports) != len(want) {
+		t.Fatalf("got %d import reports, want %d", len(policy.Imports), len(want))
+	}
+	for i, report := range policy.Imports {
+		if report.Path != want[i].path || report.Entries != want[i].entries {
+			t.Errorf("import %d: got %s with %d entries, want %s with %d", i, report.Path, report.Entries, want[i].path, want[i].entries)
+		}
+	}
+	if len(policy.Blacklist) != 3 {
+		t.Errorf("got %d blacklist entries, want 3", len(policy.Blacklist))
+	}
+}
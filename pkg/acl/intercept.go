@@ -0,0 +1,117 @@
+package acl
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"path/filepath"
+)
+
+// InterceptList is the "Intercept" section of a tenant ACL file. Tenants
+// that set it have their CONNECT tunnels decrypted with certificates signed
+// by their own CA, so that HTTPS requests are evaluated like plain HTTP.
+// Relative CA paths are resolved against the directory of the ACL file.
+// Hosts, when set, limits interception to tunnels to those hosts.
+//
+//	"Intercept": {
+//	  "CACert": "ca/tenant.crt",
+//	  "CAKey": "ca/tenant.key",
+//	  "Hosts": ["*.example.com"],
+//	  "Bypass": ["*.bank.example", "pinned.example.com"]
+//	}
+type InterceptList struct {
+	CACert string   `json:"CACert"`
+	CAKey  string   `json:"CAKey"`
+	Hosts  []string `json:"Hosts,omitempty"`
+	Bypass []string `json:"Bypass"`
+}
+
+// InterceptPolicy is the compiled form of an InterceptList.
+type InterceptPolicy struct {
+	// CA signs the certificates presented to the tenant's clients. Its Leaf is always set.
+	CA tls.Certificate
+	// CAID identifies the CA, so that minted certificates can be cached across reloads.
+	CAID string
+
+	hosts  []*Rule // empty for every host
+	bypass []*Rule
+}
+
+func compileIntercept(list *InterceptList, baseDir string) (*InterceptPolicy, error) {
+	if list == nil {
+		return nil, nil
+	}
+	if list.CACert == "" || list.CAKey == "" {
+		return nil, fmt.Errorf("both CACert and CAKey are required")
+	}
+
+	resolve := func(path string) string {
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(baseDir, path)
+	}
+	ca, err := tls.LoadX509KeyPair(resolve(list.CACert), resolve(list.CAKey))
+	if err != nil {
+		return nil, fmt.Errorf("loading CA: %w", err)
+	}
+	if ca.Leaf, err = x509.ParseCertificate(ca.Certificate[0]); err != nil {
+		return nil, fmt.Errorf("parsing CA: %w", err)
+	}
+	if !ca.Leaf.IsCA {
+		return nil, fmt.Errorf("certificate %s is not a CA", list.CACert)
+	}
+
+	hosts, err := compileRules(list.Hosts)
+	if err != nil {
+		return nil, fmt.Errorf("compiling hosts: %w", err)
+	}
+	bypass, err := compileRules(list.Bypass)
+	if err != nil {
+		return nil, fmt.Errorf("compiling bypass list: %w", err)
+	}
+
+	fingerprint := sha256.Sum256(ca.Certificate[0])
+	return &InterceptPolicy{
+		CA:     ca,
+		CAID:   hex.EncodeToString(fingerprint[:]),
+		hosts:  hosts,
+		bypass: bypass,
+	}, nil
+}
+
+// InterceptFor returns the interception settings to apply to a tunnel to
+// hostWithPort, or nil when the tunnel must be passed through untouched,
+// either because the tenant has not opted in, the host is not one of its
+// Hosts or the host is bypassed.
+func (a *ACLManager) InterceptFor(tenantName, hostWithPort string) *InterceptPolicy {
+	policy := a.Policy(tenantName)
+	if policy == nil || policy.Intercept == nil {
+		return nil
+	}
+
+	host, port, _ := net.SplitHostPort(hostWithPort)
+	if host == "" {
+		host = hostWithPort
+	}
+	listed := len(policy.Intercept.hosts) == 0
+	for _, rule := range policy.Intercept.hosts {
+		if rule.matches(host, port) {
+			listed = true
+			break
+		}
+	}
+	if !listed {
+		return nil
+	}
+	for _, rule := range policy.Intercept.bypass {
+		if rule.matches(host, port) {
+			a.logger.Debug("Tunnel to %s bypasses interception for tenant %s: %s", hostWithPort, tenantName, rule.Pattern)
+			return nil
+		}
+	}
+	return policy.Intercept
+}
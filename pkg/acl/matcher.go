@@ -0,0 +1,177 @@
+package acl
+
+import (
+	"strings"
+	"time"
+)
+
+// ruleSet is a compiled Whitelist or Blacklist. Most patterns are exact host
+// names or wildcards on the leftmost labels, so instead of running every
+// pattern's regexp on every request, they are indexed by host:
+//
+//   - exact names ("example.com") in a hash set,
+//   - "*.example.com" and "*example.com" in a trie of reversed labels
+//     (com -> example), so a lookup costs one step per label of the host,
+//   - "*" on its own in a list that matches every host.
+//
+// Other patterns (wildcards elsewhere, regexp syntax, IP and CIDR rules)
+// are tried one by one. A lookup returns the first matching rule
+// in file order, whichever index it was found in.
+type ruleSet struct {
+	exact    map[string][]indexedRule
+	suffixes *labelNode
+	any      []indexedRule
+	fallback []indexedRule
+}
+
+type indexedRule struct {
+	index int
+	rule  *Rule
+}
+
+// labelNode is a node of the reversed-label trie. The path from the root
+// to a node spells the trailing labels of a host, last label first.
+type labelNode struct {
+	children map[string]*labelNode
+	// subdomains holds "*.<path>" rules, which match hosts that have at
+	// least one more label than the path.
+	subdomains []indexedRule
+	// partial holds "*<suffix>.<path>" rules, which match hosts whose next
+	// label ends with suffix.
+	partial []partialRule
+}
+
+type partialRule struct {
+	suffix string
+	indexedRule
+}
+
+func newRuleSet(rules []*Rule) *ruleSet {
+	set := &ruleSet{
+		exact:    make(map[string][]indexedRule),
+		suffixes: &labelNode{},
+	}
+	for i, rule := range rules {
+		set.add(indexedRule{index: i, rule: rule})
+	}
+	return set
+}
+
+func (s *ruleSet) add(r indexedRule) {
+	host := r.rule.literal
+	switch {
+	case host == "":
+		s.fallback = append(s.fallback, r)
+	case host == "*":
+		s.any = append(s.any, r)
+	case strings.HasPrefix(host, "*.") && len(host) > 2:
+		node := s.suffixes.path(strings.Split(host[2:], "."))
+		node.subdomains = append(node.subdomains, r)
+	case strings.HasPrefix(host, "*"):
+		// The wildcard may also span dots, so "*example.com" matches
+		// "example.com", "badexample.com" and "a.example.com" alike: all
+		// that matters is that the label before "com" ends with "example".
+		labels := strings.Split(host[1:], ".")
+		node := s.suffixes.path(labels[1:])
+		node.partial = append(node.partial, partialRule{suffix: labels[0], indexedRule: r})
+	default:
+		s.exact[host] = append(s.exact[host], r)
+	}
+}
+
+// isLiteral reports whether a lower-cased host pattern can be matched
+// without a regexp: it holds nothing but host name characters, apart from
+// an optional leading "*".
+func isLiteral(host string) bool {
+	for i, c := range host {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.':
+		case c == '*' && i == 0:
+		default:
+			return false
+		}
+	}
+	return host != ""
+}
+
+// matchesLiteral matches a lower-cased host against a literal pattern the
+// way its regexp would: "*" matches anything, including dots and nothing.
+func matchesLiteral(pattern, host string) bool {
+	if suffix, wildcard := strings.CutPrefix(pattern, "*"); wildcard {
+		return strings.HasSuffix(host, suffix)
+	}
+	return host == pattern
+}
+
+// path returns the node for labels (in host order), creating it as needed.
+func (n *labelNode) path(labels []string) *labelNode {
+	for i := len(labels) - 1; i >= 0; i-- {
+		child, exists := n.children[labels[i]]
+		if !exists {
+			if n.children == nil {
+				n.children = make(map[string]*labelNode)
+			}
+			child = &labelNode{}
+			n.children[labels[i]] = child
+		}
+		n = child
+	}
+	return n
+}
+
+// match returns the first rule, in file order, that covers host and port
+// over the given protocol and is in force at now, or nil.
+func (s *ruleSet) match(host, port, protocol string, now time.Time) *Rule {
+	var best *indexedRule
+	consider := func(candidates []indexedRule) {
+		for i := range candidates {
+			c := &candidates[i]
+			if best != nil && c.index >= best.index {
+				return // candidates are in file order
+			}
+			if c.rule.appliesTo(protocol) && c.rule.matchesPort(port) && c.rule.activeAt(now) {
+				best = c
+				return
+			}
+		}
+	}
+
+	lower := strings.ToLower(host)
+	consider(s.exact[lower])
+	consider(s.any)
+
+	labels := strings.Split(lower, ".")
+	node := s.suffixes
+	for i := len(labels) - 1; node != nil; i-- {
+		if i >= 0 {
+			if len(node.subdomains) > 0 {
+				consider(node.subdomains)
+			}
+			for _, p := range node.partial {
+				if strings.HasSuffix(labels[i], p.suffix) {
+					consider([]indexedRule{p.indexedRule})
+				}
+			}
+		}
+		if i <= 0 {
+			break
+		}
+		node = node.children[labels[i]]
+	}
+
+	for i := range s.fallback {
+		c := &s.fallback[i]
+		if best != nil && c.index >= best.index {
+			break
+		}
+		if c.rule.appliesTo(protocol) && c.rule.matches(host, port) && c.rule.activeAt(now) {
+			best = c
+			break
+		}
+	}
+
+	if best == nil {
+		return nil
+	}
+	return best.rule
+}
@@ -0,0 +1,241 @@
+package acl
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// linearMatch is the reference ruleSet.match must agree with: the first
+// rule in file order that covers the host.
+func linearMatch(rules []*Rule, host, port, protocol string, now time.Time) *Rule {
+	for _, rule := range rules {
+		if rule.appliesTo(protocol) && rule.matches(host, port) && rule.activeAt(now) {
+			return rule
+		}
+	}
+	return nil
+}
+
+func TestRuleSetMatchFileOrder(t *testing.T) {
+	httpOnly := []string{ProtocolHTTP}
+	tests := []struct {
+		name     string
+		entries  []RuleEntry
+		host     string
+		port     string
+		protocol string
+		want     string // pattern of the matching rule, empty for none
+	}{
+		{
+			name:    "exact before suffix",
+			entries: []RuleEntry{{Pattern: "www.example.com"}, {Pattern: "*.example.com"}},
+			host:    "www.example.com",
+			want:    "www.example.com",
+		},
+		{
+			name:    "suffix before exact",
+			entries: []RuleEntry{{Pattern: "*.example.com"}, {Pattern: "www.example.com"}},
+			host:    "www.example.com",
+			want:    "*.example.com",
+		},
+		{
+			name:    "regexp before exact",
+			entries: []RuleEntry{{Pattern: "www.*.com"}, {Pattern: "www.example.com"}},
+			host:    "www.example.com",
+			want:    "www.*.com",
+		},
+		{
+			name:    "exact before regexp",
+			entries: []RuleEntry{{Pattern: "www.example.com"}, {Pattern: "www.*.com"}},
+			host:    "www.example.com",
+			want:    "www.example.com",
+		},
+		{
+			name:    "regexp before suffix",
+			entries: []RuleEntry{{Pattern: "api-*.example.com"}, {Pattern: "*.example.com"}},
+			host:    "api-v2.example.com",
+			want:    "api-*.example.com",
+		},
+		{
+			name:    "suffix before regexp",
+			entries: []RuleEntry{{Pattern: "*.example.com"}, {Pattern: "api-*.example.com"}},
+			host:    "api-v2.example.com",
+			want:    "*.example.com",
+		},
+		{
+			name:    "any host before exact",
+			entries: []RuleEntry{{Pattern: "*"}, {Pattern: "www.example.com"}},
+			host:    "www.example.com",
+			want:    "*",
+		},
+		{
+			name:    "exact before any host",
+			entries: []RuleEntry{{Pattern: "www.example.com"}, {Pattern: "*"}},
+			host:    "www.example.com",
+			want:    "www.example.com",
+		},
+		{
+			name:    "shallower suffix first",
+			entries: []RuleEntry{{Pattern: "*.example.com"}, {Pattern: "*.b.example.com"}},
+			host:    "a.b.example.com",
+			want:    "*.example.com",
+		},
+		{
+			name:    "deeper suffix first",
+			entries: []RuleEntry{{Pattern: "*.b.example.com"}, {Pattern: "*.example.com"}},
+			host:    "a.b.example.com",
+			want:    "*.b.example.com",
+		},
+		{
+			name:    "partial label suffix",
+			entries: []RuleEntry{{Pattern: "*example.com"}, {Pattern: "badexample.com"}},
+			host:    "badexample.com",
+			want:    "*example.com",
+		},
+		{
+			name:    "subdomain suffix skips the domain itself",
+			entries: []RuleEntry{{Pattern: "*.example.com"}, {Pattern: "example.com"}},
+			host:    "example.com",
+			want:    "example.com",
+		},
+		{
+			name:    "host is matched case-insensitively",
+			entries: []RuleEntry{{Pattern: "www.example.com"}, {Pattern: "*.example.com"}},
+			host:    "WWW.Example.COM",
+			want:    "www.example.com",
+		},
+		{
+			name:    "rule for another port is skipped",
+			entries: []RuleEntry{{Pattern: "www.example.com:8443"}, {Pattern: "*.example.com"}},
+			host:    "www.example.com",
+			port:    "443",
+			want:    "*.example.com",
+		},
+		{
+			name:    "rule for another protocol is skipped",
+			entries: []RuleEntry{{Pattern: "www.example.com", Protocols: httpOnly}, {Pattern: "www.*.com"}},
+			host:    "www.example.com",
+			want:    "www.*.com",
+		},
+		{
+			name:     "rule for the protocol matches",
+			entries:  []RuleEntry{{Pattern: "www.example.com", Protocols: httpOnly}, {Pattern: "www.*.com"}},
+			host:     "www.example.com",
+			protocol: ProtocolHTTP,
+			want:     "www.example.com",
+		},
+		{
+			name:    "network before any host",
+			entries: []RuleEntry{{Pattern: "10.0.0.0/8"}, {Pattern: "*"}},
+			host:    "10.1.2.3",
+			want:    "10.0.0.0/8",
+		},
+		{
+			name:    "any host before network",
+			entries: []RuleEntry{{Pattern: "*"}, {Pattern: "10.0.0.0/8"}},
+			host:    "10.1.2.3",
+			want:    "*",
+		},
+		{
+			name:    "no match",
+			entries: []RuleEntry{{Pattern: "www.example.com"}, {Pattern: "*.example.net"}, {Pattern: "api-*.example.org"}},
+			host:    "example.org",
+			want:    "",
+		},
+	}
+
+	now := time.Now()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := compileEntries(tt.entries, nil)
+			if err != nil {
+				t.Fatalf("compiling rules: %v", err)
+			}
+			port, protocol := tt.port, tt.protocol
+			if port == "" {
+				port = "443"
+			}
+			if protocol == "" {
+				protocol = ProtocolConnect
+			}
+
+			got := newRuleSet(rules).match(tt.host, port, protocol, now)
+			if pattern := patternOf(got); pattern != tt.want {
+				t.Errorf("match(%q) = %q, want %q", tt.host, pattern, tt.want)
+			}
+			if linear := linearMatch(rules, tt.host, port, protocol, now); got != linear {
+				t.Errorf("match(%q) = %q, linear scan finds %q", tt.host, patternOf(got), patternOf(linear))
+			}
+		})
+	}
+}
+
+func patternOf(rule *Rule) string {
+	if rule == nil {
+		return ""
+	}
+	return rule.Pattern
+}
+
+// generatedPatterns returns n patterns of the given kind: exact names,
+// subdomain suffixes, or wildcards in the middle that only the regexp
+// fallback can match.
+func generatedPatterns(kind string, n int) []string {
+	patterns := make([]string, n)
+	for i := range patterns {
+		switch kind {
+		case "exact":
+			patterns[i] = fmt.Sprintf("host%d.example%d.com", i, i%97)
+		case "suffix":
+			patterns[i] = fmt.Sprintf("*.site%d.example%d.com", i, i%97)
+		case "regexp":
+			patterns[i] = fmt.Sprintf("api-*.site%d.example%d.com", i, i%97)
+		}
+	}
+	return patterns
+}
+
+// generatedHost returns a host covered by the i-th pattern of the kind.
+func generatedHost(kind string, i int) string {
+	switch kind {
+	case "exact":
+		return fmt.Sprintf("host%d.example%d.com", i, i%97)
+	case "suffix":
+		return fmt.Sprintf("www.site%d.example%d.com", i, i%97)
+	default:
+		return fmt.Sprintf("api-v1.site%d.example%d.com", i, i%97)
+	}
+}
+
+func BenchmarkRuleSetMatch(b *testing.B) {
+	now := time.Now()
+	for _, kind := range []string{"exact", "suffix", "regexp"} {
+		for _, size := range []int{1_000, 50_000, 500_000} {
+			b.Run(fmt.Sprintf("%s/%d", kind, size), func(b *testing.B) {
+				rules, err := compileRules(generatedPatterns(kind, size))
+				if err != nil {
+					b.Fatal(err)
+				}
+				set := newRuleSet(rules)
+
+				// Half of the lookups hit a rule spread across the set, the
+				// other half miss every rule.
+				hosts := make([]string, 256)
+				for i := range hosts {
+					if i%2 == 0 {
+						hosts[i] = generatedHost(kind, i*size/len(hosts))
+					} else {
+						hosts[i] = fmt.Sprintf("miss%d.example.org", i)
+					}
+				}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					set.match(hosts[i%len(hosts)], "443", ProtocolConnect, now)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,447 @@
+package acl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// List is the on-disk format of a tenant ACL file (<tenant>.json) and of a
+// policy group file (groups/<group>.json).
+type List struct {
+	Groups     []string                `json:"Groups,omitempty"`
+	Whitelist  []RuleEntry             `json:"Whitelist"`
+	Blacklist  []RuleEntry             `json:"Blacklist"`
+	Overrides  []RuleEntry             `json:"Overrides,omitempty"`
+	Upstream   *UpstreamList           `json:"Upstream,omitempty"`
+	Intercept  *InterceptList          `json:"Intercept,omitempty"`
+	Tunnel     *TunnelList             `json:"Tunnel,omitempty"`
+	Forwarding *ForwardingList         `json:"Forwarding,omitempty"`
+	RateLimit  *RateLimitList          `json:"RateLimit,omitempty"`
+	Bandwidth  *BandwidthList          `json:"Bandwidth,omitempty"`
+	Quota      *QuotaList              `json:"Quota,omitempty"`
+	Scheduling *SchedulingList         `json:"Scheduling,omitempty"`
+	Addresses  *AddressList            `json:"Addresses,omitempty"`
+	Imports    []ImportEntry           `json:"Imports,omitempty"`
+	Schedules  map[string]ScheduleList `json:"Schedules,omitempty"`
+}
+
+// Policy is the immutable, precompiled form of a tenant List, merged with
+// the groups the tenant belongs to. A Policy is never modified after it
+// has been published, so it can be shared freely between request
+// goroutines. Groups are compiled into policies of their own, which are
+// shared by their tenants.
+type Policy struct {
+	Tenant     string // or the group name, for groups
+	Groups     []string
+	Whitelist  []*Rule // the tenant's own; those of groups are in their policies
+	Blacklist  []*Rule
+	Overrides  []*Rule
+	Upstream   *UpstreamPolicy
+	Intercept  *InterceptPolicy
+	Tunnel     *TunnelPolicy
+	Forwarding *ForwardingPolicy
+	RateLimit  *RateLimitPolicy
+	Bandwidth  *BandwidthPolicy
+	Quota      *QuotaPolicy
+	Scheduling *SchedulingPolicy
+	Addresses  *AddressPolicy
+	Imports    []ImportReport
+
+	// whitelist, blacklist and overrides index the rules above for lookups.
+	whitelist *ruleSet
+	blacklist *ruleSet
+	overrides *ruleSet
+	// groups are the compiled groups, in the order of Groups.
+	groups []*Policy
+	// list is the source of a group, from which tenants inherit sections.
+	list *List
+
+	modTime time.Time
+	size    int64
+	// sources are the imported list files, which are reloaded with the ACL file.
+	sources []fileStamp
+}
+
+// RuleEntry is a Whitelist or Blacklist entry. It is either a plain pattern
+// string, which applies to every protocol at all times, or an object that
+// restricts the pattern to some protocols or to the windows of a schedule:
+//
+//	"*:443"
+//	{"Pattern": "*:80", "Protocols": ["http"]}
+//	{"Pattern": "*.facebook.com", "Schedule": "lunch"}
+type RuleEntry struct {
+	Pattern   string   `json:"Pattern"`
+	Protocols []string `json:"Protocols,omitempty"`
+	Schedule  string   `json:"Schedule,omitempty"`
+}
+
+// UnmarshalJSON accepts both the string and the object form of an entry.
+func (e *RuleEntry) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		e.Protocols, e.Schedule = nil, ""
+		return json.Unmarshal(data, &e.Pattern)
+	}
+	type plain RuleEntry
+	return json.Unmarshal(data, (*plain)(e))
+}
+
+// Protocols a rule can be restricted to. ProtocolConnect covers tunnels of
+// every kind: CONNECT requests, SOCKS5 and transparent connections.
+// ProtocolHTTP covers plain HTTP requests, including the decrypted requests
+// of intercepted tunnels.
+const (
+	ProtocolConnect = "connect"
+	ProtocolHTTP    = "http"
+)
+
+// Kinds of rule patterns, detected when the pattern is compiled.
+const (
+	RuleHost = "host" // host name with optional * wildcards
+	RuleIP   = "ip"   // single IPv4 or IPv6 address
+	RuleCIDR = "cidr" // IPv4 or IPv6 subnet
+)
+
+// Rule is a single compiled ACL pattern. Host rules match the name the
+// client asked for; IP and CIDR rules match destinations given as IP
+// addresses.
+type Rule struct {
+	Pattern   string
+	Kind      string
+	Host      string
+	Port      string         // a port or a port range such as 5432-5439; empty for any port
+	Protocols []string       // empty for every protocol
+	Schedule  *Schedule      // nil for all times
+	Group     string         // the group the rule comes from; empty for the tenant's own rules
+	Regex     *regexp.Regexp // host rules that are not literal only
+	Network   *net.IPNet     // IP and CIDR rules only
+
+	portLow, portHigh int // 0 for any port
+
+	// literal is the lower-cased host of a rule that needs no regexp: a
+	// plain name, "*", or "*" followed by a suffix.
+	literal string
+}
+
+// loadPolicy reads and compiles the ACL file at filePath for the given
+// tenant, merged with the groups it names.
+func loadPolicy(tenantName, filePath string, info os.FileInfo, groups map[string]*Policy) (*Policy, error) {
+	list, err := readList(filePath)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := resolvePolicy(tenantName, list, filepath.Dir(filePath), groups)
+	if err != nil {
+		return nil, err
+	}
+	policy.modTime = info.ModTime()
+	policy.size = info.Size()
+	return policy, nil
+}
+
+// resolvePolicy compiles a tenant list together with the groups it names.
+func resolvePolicy(tenantName string, list *List, baseDir string, groups map[string]*Policy) (*Policy, error) {
+	members := make([]*Policy, 0, len(list.Groups))
+	for _, name := range list.Groups {
+		group, exists := groups[name]
+		if !exists {
+			return nil, fmt.Errorf("unknown group %q", name)
+		}
+		members = append(members, group)
+	}
+	inheritSections(list, members)
+	return compilePolicy(tenantName, list, baseDir, members)
+}
+
+func readList(filePath string) (*List, error) {
+	fileContent, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading list file: %w", err)
+	}
+
+	list := &List{}
+	if err := json.Unmarshal(fileContent, list); err != nil {
+		return nil, fmt.Errorf("parsing list file: %w", err)
+	}
+	return list, nil
+}
+
+// compilePolicy turns a List into a Policy, compiling every pattern once.
+// Relative file paths in the list are resolved against baseDir. The rules
+// of groups are not copied; the policy refers to the compiled groups.
+func compilePolicy(tenantName string, list *List, baseDir string, groups []*Policy) (*Policy, error) {
+	policy := &Policy{Tenant: tenantName, Groups: list.Groups, groups: groups}
+
+	schedules, err := compileSchedules(list.Schedules)
+	if err != nil {
+		return nil, fmt.Errorf("compiling schedules: %w", err)
+	}
+	if policy.Whitelist, err = compileEntries(list.Whitelist, schedules); err != nil {
+		return nil, fmt.Errorf("compiling whitelist: %w", err)
+	}
+	if policy.Blacklist, err = compileEntries(list.Blacklist, schedules); err != nil {
+		return nil, fmt.Errorf("compiling blacklist: %w", err)
+	}
+	if policy.Overrides, err = compileEntries(list.Overrides, schedules); err != nil {
+		return nil, fmt.Errorf("compiling overrides: %w", err)
+	}
+	if err := policy.importLists(list.Imports, baseDir, schedules); err != nil {
+		return nil, fmt.Errorf("importing lists: %w", err)
+	}
+	policy.whitelist = newRuleSet(policy.Whitelist)
+	policy.blacklist = newRuleSet(policy.Blacklist)
+	policy.overrides = newRuleSet(policy.Overrides)
+	if policy.Upstream, err = compileUpstream(list.Upstream); err != nil {
+		return nil, fmt.Errorf("compiling upstream: %w", err)
+	}
+	if policy.Intercept, err = compileIntercept(list.Intercept, baseDir); err != nil {
+		return nil, fmt.Errorf("compiling intercept: %w", err)
+	}
+	if policy.Tunnel, err = compileTunnel(list.Tunnel); err != nil {
+		return nil, fmt.Errorf("compiling tunnel: %w", err)
+	}
+	policy.Forwarding = compileForwarding(list.Forwarding)
+	if policy.RateLimit, err = compileRateLimit(list.RateLimit); err != nil {
+		return nil, fmt.Errorf("compiling rate limit: %w", err)
+	}
+	if policy.Bandwidth, err = compileBandwidth(list.Bandwidth); err != nil {
+		return nil, fmt.Errorf("compiling bandwidth: %w", err)
+	}
+	if policy.Quota, err = compileQuota(list.Quota); err != nil {
+		return nil, fmt.Errorf("compiling quota: %w", err)
+	}
+	if policy.Scheduling, err = compileScheduling(list.Scheduling); err != nil {
+		return nil, fmt.Errorf("compiling scheduling: %w", err)
+	}
+	var groupBlacklist []*Rule
+	for _, group := range groups {
+		groupBlacklist = append(groupBlacklist, group.Blacklist...)
+	}
+	if policy.Addresses, err = compileAddresses(list.Addresses, policy.Blacklist, policy.Overrides, groupBlacklist); err != nil {
+		return nil, fmt.Errorf("compiling addresses: %w", err)
+	}
+	return policy, nil
+}
+
+// importLists loads the imported list files and appends their domains to
+// the lists they go into, after the entries of the ACL file itself.
+func (p *Policy) importLists(imports []ImportEntry, baseDir string, schedules map[string]*Schedule) error {
+	for _, entry := range imports {
+		entries, report, stamp, err := loadImport(entry, baseDir)
+		if err != nil {
+			return err
+		}
+		rules, err := compileEntries(entries, schedules)
+		if err != nil {
+			return fmt.Errorf("%s: %w", report.Path, err)
+		}
+		if report.Into == IntoWhitelist {
+			p.Whitelist = append(p.Whitelist, rules...)
+		} else {
+			p.Blacklist = append(p.Blacklist, rules...)
+		}
+		p.Imports = append(p.Imports, report)
+		p.sources = append(p.sources, stamp)
+	}
+	return nil
+}
+
+// sourcesChanged reports whether an imported list file changed since the policy was loaded.
+func (p *Policy) sourcesChanged() bool {
+	for _, source := range p.sources {
+		if source.changed() {
+			return true
+		}
+	}
+	return false
+}
+
+func compileEntries(entries []RuleEntry, schedules map[string]*Schedule) ([]*Rule, error) {
+	rules := make([]*Rule, 0, len(entries))
+	for _, entry := range entries {
+		rule, err := compileRule(entry.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, protocol := range entry.Protocols {
+			if protocol != ProtocolConnect && protocol != ProtocolHTTP {
+				return nil, fmt.Errorf("pattern %q: unknown protocol %q, expected connect or http", entry.Pattern, protocol)
+			}
+		}
+		rule.Protocols = entry.Protocols
+		if entry.Schedule != "" {
+			if rule.Schedule = schedules[entry.Schedule]; rule.Schedule == nil {
+				return nil, fmt.Errorf("pattern %q: unknown schedule %q", entry.Pattern, entry.Schedule)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func compileRules(patterns []string) ([]*Rule, error) {
+	rules := make([]*Rule, 0, len(patterns))
+	for _, pattern := range patterns {
+		rule, err := compileRule(pattern)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// compileRule compiles a pattern of the form host, host:port, ip, [ip]:port,
+// cidr or cidr:port. IPv6 addresses and subnets need brackets only when a
+// port follows. The port can be a range such as 8000-8099, or * for any.
+func compileRule(pattern string) (*Rule, error) {
+	patternHost, patternPort, err := net.SplitHostPort(pattern)
+	if err != nil || patternHost == "" {
+		patternHost, patternPort = strings.Trim(pattern, "[]"), ""
+	}
+	if patternPort == "*" {
+		patternPort = ""
+	}
+	rule := &Rule{Pattern: pattern, Host: patternHost, Port: patternPort}
+	if rule.portLow, rule.portHigh, err = parsePortRange(patternPort); err != nil {
+		return nil, fmt.Errorf("pattern %q: %w", pattern, err)
+	}
+
+	if strings.Contains(patternHost, "/") {
+		_, network, err := net.ParseCIDR(patternHost)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+		rule.Kind, rule.Network = RuleCIDR, network
+		return rule, nil
+	}
+	if ip := net.ParseIP(patternHost); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		rule.Kind, rule.Network = RuleIP, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		return rule, nil
+	}
+	if strings.Contains(patternHost, ":") {
+		return nil, fmt.Errorf("pattern %q: invalid host, IPv6 addresses with a port need brackets", pattern)
+	}
+
+	rule.Kind = RuleHost
+	if literal := strings.ToLower(patternHost); isLiteral(literal) {
+		rule.literal = literal
+		return rule, nil
+	}
+	regex, err := regexp.Compile(wildcardToRegex(patternHost))
+	if err != nil {
+		return nil, fmt.Errorf("pattern %q: %w", pattern, err)
+	}
+	rule.Regex = regex
+	return rule, nil
+}
+
+// parsePortRange parses a port or a low-high port range. An empty string is any port.
+func parsePortRange(ports string) (int, int, error) {
+	if ports == "" {
+		return 0, 0, nil
+	}
+	lowText, highText, isRange := strings.Cut(ports, "-")
+	if !isRange {
+		highText = lowText
+	}
+	low, err := strconv.Atoi(lowText)
+	if err != nil || low < 1 || low > 65535 {
+		return 0, 0, fmt.Errorf("invalid port %q", lowText)
+	}
+	high, err := strconv.Atoi(highText)
+	if err != nil || high < 1 || high > 65535 {
+		return 0, 0, fmt.Errorf("invalid port %q", highText)
+	}
+	if low > high {
+		return 0, 0, fmt.Errorf("invalid port range %q", ports)
+	}
+	return low, high, nil
+}
+
+// matchesPort reports whether port is in the rule's port range.
+func (r *Rule) matchesPort(port string) bool {
+	if r.portLow == 0 {
+		return true
+	}
+	p, err := strconv.Atoi(port)
+	return err == nil && p >= r.portLow && p <= r.portHigh
+}
+
+// appliesTo reports whether the rule covers the protocol (ProtocolConnect or ProtocolHTTP).
+func (r *Rule) appliesTo(protocol string) bool {
+	if len(r.Protocols) == 0 {
+		return true
+	}
+	for _, p := range r.Protocols {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// String describes the rule for logs, for example
+// "host rule *.example.com:443 (schedule lunch, group pci)".
+func (r *Rule) String() string {
+	var details []string
+	if r.Schedule != nil {
+		details = append(details, "schedule "+r.Schedule.Name)
+	}
+	if r.Group != "" {
+		details = append(details, "group "+r.Group)
+	}
+	if len(details) == 0 {
+		return fmt.Sprintf("%s rule %s", r.Kind, r.Pattern)
+	}
+	return fmt.Sprintf("%s rule %s (%s)", r.Kind, r.Pattern, strings.Join(details, ", "))
+}
+
+// activeAt reports whether the rule's schedule, if any, is open at now.
+func (r *Rule) activeAt(now time.Time) bool {
+	return r.Schedule == nil || r.Schedule.Active(now)
+}
+
+// matches reports whether host and port are covered by the rule. IP and
+// CIDR rules only match hosts that are IP addresses.
+func (r *Rule) matches(host, port string) bool {
+	if !r.matchesPort(port) {
+		return false
+	}
+	if r.Network != nil {
+		ip := net.ParseIP(strings.Trim(host, "[]"))
+		return ip != nil && r.Network.Contains(ip)
+	}
+	if r.literal != "" {
+		return matchesLiteral(r.literal, strings.ToLower(host))
+	}
+	return r.Regex.MatchString(host)
+}
+
+// matchesIP reports whether the rule covers the address ip and port. Host
+// rules never match addresses.
+func (r *Rule) matchesIP(ip net.IP, port string) bool {
+	if r.Network == nil || !r.matchesPort(port) {
+		return false
+	}
+	return r.Network.Contains(ip)
+}
+
+func wildcardToRegex(pattern string) string {
+	pattern = strings.Replace(pattern, "*", ".*", -1)
+	pattern = strings.Replace(pattern, ".", "\\.", -1)  // Escape actual dots for regex
+	pattern = strings.Replace(pattern, "\\.*", ".*", 1) // Replace the first occurrence of '\.*' with '.*'
+
+	// Make the regex case-insensitive and ensure it matches the entire host
+	return "(?i)^" + pattern + "$"
+}
@@ -0,0 +1,90 @@
+package acl
+
+import (
+	"fmt"
+
+	"github.com/clodevo/raven-proxy/pkg/ratelimit"
+	"github.com/clodevo/raven-proxy/pkg/usage"
+)
+
+// Actions taken once a quota is exhausted.
+const (
+	QuotaBlock    = "block"
+	QuotaThrottle = "throttle"
+)
+
+// QuotaList is the "Quota" section of a tenant ACL file. It caps the
+// requests and bytes (in both directions) a tenant may use per calendar day
+// or month, in UTC. A zero cap means unlimited.
+//
+//	"Quota": {
+//	  "Period": "monthly",
+//	  "Requests": 1000000,
+//	  "Bytes": 107374182400,
+//	  "Action": "throttle",
+//	  "ThrottleBytesPerSecond": 65536
+//	}
+type QuotaList struct {
+	Period                 string  `json:"Period"`
+	Requests               uint64  `json:"Requests"`
+	Bytes                  uint64  `json:"Bytes"`
+	Action                 string  `json:"Action"`
+	ThrottleBytesPerSecond float64 `json:"ThrottleBytesPerSecond"`
+}
+
+// QuotaPolicy is the compiled form of a QuotaList.
+type QuotaPolicy struct {
+	Period   string
+	Requests uint64
+	Bytes    uint64
+	Action   string
+	// Throttle caps the tenant's traffic once the quota is exhausted and Action is QuotaThrottle.
+	Throttle ratelimit.Limit
+}
+
+func compileQuota(list *QuotaList) (*QuotaPolicy, error) {
+	if list == nil {
+		return nil, nil
+	}
+
+	policy := &QuotaPolicy{
+		Period:   list.Period,
+		Requests: list.Requests,
+		Bytes:    list.Bytes,
+		Action:   list.Action,
+		Throttle: ratelimit.Limit{Rate: list.ThrottleBytesPerSecond},
+	}
+	switch policy.Period {
+	case "":
+		policy.Period = usage.PeriodMonthly
+	case usage.PeriodDaily, usage.PeriodMonthly:
+	default:
+		return nil, fmt.Errorf("unknown period %q, expected daily or monthly", list.Period)
+	}
+	switch policy.Action {
+	case "":
+		policy.Action = QuotaBlock
+	case QuotaBlock:
+	case QuotaThrottle:
+		if list.ThrottleBytesPerSecond <= 0 {
+			return nil, fmt.Errorf("the throttle action needs a positive ThrottleBytesPerSecond")
+		}
+	default:
+		return nil, fmt.Errorf("unknown action %q, expected block or throttle", list.Action)
+	}
+	return policy, nil
+}
+
+// Exhausted reports whether totals have used up the quota.
+func (q *QuotaPolicy) Exhausted(totals usage.Totals) bool {
+	return (q.Requests > 0 && totals.Requests >= q.Requests) || (q.Bytes > 0 && totals.Bytes() >= q.Bytes)
+}
+
+// QuotaFor returns the quota of a tenant, or nil when it has none.
+func (a *ACLManager) QuotaFor(tenantName string) *QuotaPolicy {
+	policy := a.Policy(tenantName)
+	if policy == nil {
+		return nil
+	}
+	return policy.Quota
+}
@@ -0,0 +1,168 @@
+package acl
+
+import (
+	"fmt"
+
+	"github.com/clodevo/raven-proxy/pkg/ratelimit"
+	"github.com/clodevo/raven-proxy/pkg/utils"
+)
+
+// RateLimitList is the "RateLimit" section of a tenant ACL file. Limits
+// are shared by all keys of the tenant, except for keys listed under Keys,
+// which get buckets of their own. Fields a key leaves out fall back to the
+// tenant's values, and a zero rate means unlimited.
+//
+// The caps on open tunnels and in-flight requests work differently: the
+// tenant's caps always count all of its keys, and a key's caps further
+// restrict that key alone. A zero cap means unlimited.
+//
+//	"RateLimit": {
+//	  "RequestsPerSecond": 50,
+//	  "RequestBurst": 100,
+//	  "TunnelsPerSecond": 5,
+//	  "TunnelBurst": 10,
+//	  "MaxTunnels": 200,
+//	  "MaxInFlightRequests": 100,
+//	  "Keys": {
+//	    "<api_key_id>": {"RequestsPerSecond": 5, "MaxTunnels": 20}
+//	  }
+//	}
+type RateLimitList struct {
+	RateLimitSettings
+	Keys map[string]RateLimitSettings `json:"Keys"`
+}
+
+// RateLimitSettings are the limits of a tenant or of a single API key.
+type RateLimitSettings struct {
+	RequestsPerSecond float64 `json:"RequestsPerSecond"`
+	RequestBurst      int     `json:"RequestBurst"`
+	TunnelsPerSecond  float64 `json:"TunnelsPerSecond"`
+	TunnelBurst       int     `json:"TunnelBurst"`
+	// MaxTunnels caps simultaneously open tunnels and MaxInFlightRequests
+	// simultaneously forwarded HTTP requests.
+	MaxTunnels          int `json:"MaxTunnels"`
+	MaxInFlightRequests int `json:"MaxInFlightRequests"`
+}
+
+// RateLimitPolicy is the compiled form of a RateLimitList.
+type RateLimitPolicy struct {
+	tenant rateLimits
+	keys   map[string]rateLimits
+}
+
+type rateLimits struct {
+	requests ratelimit.Limit
+	tunnels  ratelimit.Limit
+
+	maxRequests int
+	maxTunnels  int
+}
+
+func (s RateLimitSettings) validate() error {
+	if s.RequestsPerSecond < 0 || s.TunnelsPerSecond < 0 || s.RequestBurst < 0 || s.TunnelBurst < 0 {
+		return fmt.Errorf("rates and bursts must not be negative")
+	}
+	if s.MaxTunnels < 0 || s.MaxInFlightRequests < 0 {
+		return fmt.Errorf("concurrency caps must not be negative")
+	}
+	return nil
+}
+
+// merge returns s with the rates and bursts it leaves out taken from base.
+// Concurrency caps are not merged, since the tenant's caps apply anyway.
+func (s RateLimitSettings) merge(base RateLimitSettings) RateLimitSettings {
+	if s.RequestsPerSecond == 0 {
+		s.RequestsPerSecond = base.RequestsPerSecond
+	}
+	if s.RequestBurst == 0 {
+		s.RequestBurst = base.RequestBurst
+	}
+	if s.TunnelsPerSecond == 0 {
+		s.TunnelsPerSecond = base.TunnelsPerSecond
+	}
+	if s.TunnelBurst == 0 {
+		s.TunnelBurst = base.TunnelBurst
+	}
+	return s
+}
+
+func (s RateLimitSettings) limits() rateLimits {
+	return rateLimits{
+		requests: ratelimit.Limit{Rate: s.RequestsPerSecond, Burst: s.RequestBurst},
+		tunnels:  ratelimit.Limit{Rate: s.TunnelsPerSecond, Burst: s.TunnelBurst},
+
+		maxRequests: s.MaxInFlightRequests,
+		maxTunnels:  s.MaxTunnels,
+	}
+}
+
+func compileRateLimit(list *RateLimitList) (*RateLimitPolicy, error) {
+	if list == nil {
+		return nil, nil
+	}
+	if err := list.validate(); err != nil {
+		return nil, err
+	}
+
+	policy := &RateLimitPolicy{
+		tenant: list.RateLimitSettings.limits(),
+		keys:   make(map[string]rateLimits, len(list.Keys)),
+	}
+	for apiKeyID, settings := range list.Keys {
+		if err := settings.validate(); err != nil {
+			return nil, fmt.Errorf("key %s: %w", apiKeyID, err)
+		}
+		policy.keys[apiKeyID] = settings.merge(list.RateLimitSettings).limits()
+	}
+	return policy, nil
+}
+
+// RateLimitFor returns the bucket and limit that apply to an operation of
+// the given kind (ratelimit.KindRequests or ratelimit.KindTunnels) by the
+// identity. The limit is unlimited when the tenant sets none.
+func (a *ACLManager) RateLimitFor(id *utils.Identity, kind string) (ratelimit.BucketID, ratelimit.Limit) {
+	bucket := ratelimit.BucketID{Tenant: id.TenantName, Kind: kind}
+	policy := a.Policy(id.TenantName)
+	if policy == nil || policy.RateLimit == nil {
+		return bucket, ratelimit.Limit{}
+	}
+
+	limits := policy.RateLimit.tenant
+	if keyLimits, exists := policy.RateLimit.keys[id.APIKeyID]; exists {
+		limits = keyLimits
+		bucket.APIKeyID = id.APIKeyID
+	}
+	if kind == ratelimit.KindTunnels {
+		return bucket, limits.tunnels
+	}
+	return bucket, limits.requests
+}
+
+// ConcurrencyFor returns the caps that an operation of the given kind
+// (ratelimit.KindRequests or ratelimit.KindTunnels) by the identity must fit
+// in: the tenant's, and the key's own when the tenant lists the key. Every
+// tenant gets a slot, unlimited if need be, so that occupancy is reported
+// for all of them.
+func (a *ACLManager) ConcurrencyFor(id *utils.Identity, kind string) []ratelimit.Slot {
+	slots := []ratelimit.Slot{{ID: ratelimit.BucketID{Tenant: id.TenantName, Kind: kind}}}
+	policy := a.Policy(id.TenantName)
+	if policy == nil || policy.RateLimit == nil {
+		return slots
+	}
+
+	slots[0].Max = policy.RateLimit.tenant.max(kind)
+	if keyLimits, exists := policy.RateLimit.keys[id.APIKeyID]; exists && keyLimits.max(kind) > 0 {
+		slots = append(slots, ratelimit.Slot{
+			ID:  ratelimit.BucketID{Tenant: id.TenantName, APIKeyID: id.APIKeyID, Kind: kind},
+			Max: keyLimits.max(kind),
+		})
+	}
+	return slots
+}
+
+func (l rateLimits) max(kind string) int {
+	if kind == ratelimit.KindTunnels {
+		return l.maxTunnels
+	}
+	return l.maxRequests
+}
@@ -0,0 +1,196 @@
+package acl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	// Schedules name IANA time zones, and the runtime image has no zoneinfo.
+	_ "time/tzdata"
+)
+
+// ScheduleList is an entry of the "Schedules" section of a tenant ACL
+// file. Whitelist and Blacklist entries that name a schedule only apply
+// while one of its windows is open.
+//
+//	"Schedules": {
+//	  "lunch": {
+//	    "TimeZone": "Europe/Berlin",
+//	    "Windows": [{"Days": ["weekdays"], "From": "12:00", "To": "13:00"}]
+//	  },
+//	  "maintenance": {
+//	    "TimeZone": "America/New_York",
+//	    "Windows": [{"Start": "2026-11-07T22:00", "End": "2026-11-08T04:00"}]
+//	  }
+//	}
+type ScheduleList struct {
+	TimeZone string        `json:"TimeZone,omitempty"`
+	Windows  []WindowEntry `json:"Windows"`
+}
+
+// WindowEntry is a window of a schedule. Days are mon to sun, weekdays or
+// weekends, and default to every day. From and To are times of day in the
+// schedule's time zone and default to the whole day; a To that is not
+// after From ends the window on the next day. Start and End bound the
+// window to a period, as dates with an optional time in the schedule's
+// time zone, or RFC 3339 timestamps.
+type WindowEntry struct {
+	Days  []string `json:"Days,omitempty"`
+	From  string   `json:"From,omitempty"`
+	To    string   `json:"To,omitempty"`
+	Start string   `json:"Start,omitempty"`
+	End   string   `json:"End,omitempty"`
+}
+
+// Schedule is the compiled form of a ScheduleList.
+type Schedule struct {
+	Name     string
+	location *time.Location
+	windows  []window
+}
+
+type window struct {
+	days       [7]bool // indexed by time.Weekday
+	from, to   int     // minutes since midnight
+	start, end time.Time
+}
+
+const minutesPerDay = 24 * 60
+
+var weekdayNames = map[string][]time.Weekday{
+	"sun":      {time.Sunday},
+	"mon":      {time.Monday},
+	"tue":      {time.Tuesday},
+	"wed":      {time.Wednesday},
+	"thu":      {time.Thursday},
+	"fri":      {time.Friday},
+	"sat":      {time.Saturday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekends": {time.Saturday, time.Sunday},
+}
+
+// dateLayouts are the accepted forms of window Start and End.
+var dateLayouts = []string{time.RFC3339, "2006-01-02T15:04", "2006-01-02 15:04", "2006-01-02"}
+
+func compileSchedules(lists map[string]ScheduleList) (map[string]*Schedule, error) {
+	schedules := make(map[string]*Schedule, len(lists))
+	for name, list := range lists {
+		schedule, err := compileSchedule(name, list)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", name, err)
+		}
+		schedules[name] = schedule
+	}
+	return schedules, nil
+}
+
+func compileSchedule(name string, list ScheduleList) (*Schedule, error) {
+	location := time.UTC
+	if list.TimeZone != "" {
+		var err error
+		if location, err = time.LoadLocation(list.TimeZone); err != nil {
+			return nil, err
+		}
+	}
+	if len(list.Windows) == 0 {
+		return nil, fmt.Errorf("no windows")
+	}
+
+	schedule := &Schedule{Name: name, location: location}
+	for i, entry := range list.Windows {
+		w, err := compileWindow(entry, location)
+		if err != nil {
+			return nil, fmt.Errorf("window %d: %w", i+1, err)
+		}
+		schedule.windows = append(schedule.windows, w)
+	}
+	return schedule, nil
+}
+
+func compileWindow(entry WindowEntry, location *time.Location) (window, error) {
+	w := window{to: minutesPerDay}
+	if len(entry.Days) == 0 {
+		w.days = [7]bool{true, true, true, true, true, true, true}
+	}
+	for _, name := range entry.Days {
+		days, exists := weekdayNames[strings.ToLower(name)]
+		if !exists {
+			return w, fmt.Errorf("unknown day %q, expected mon to sun, weekdays or weekends", name)
+		}
+		for _, day := range days {
+			w.days[day] = true
+		}
+	}
+
+	var err error
+	if entry.From != "" {
+		if w.from, err = parseTimeOfDay(entry.From); err != nil {
+			return w, err
+		}
+	}
+	if entry.To != "" {
+		if w.to, err = parseTimeOfDay(entry.To); err != nil {
+			return w, err
+		}
+	}
+	if entry.Start != "" {
+		if w.start, err = parseDate(entry.Start, location); err != nil {
+			return w, err
+		}
+	}
+	if entry.End != "" {
+		if w.end, err = parseDate(entry.End, location); err != nil {
+			return w, err
+		}
+		if !w.start.IsZero() && !w.end.After(w.start) {
+			return w, fmt.Errorf("end %s is not after start %s", entry.End, entry.Start)
+		}
+	}
+	return w, nil
+}
+
+// parseTimeOfDay parses HH:MM, from 00:00 to 24:00, into minutes since midnight.
+func parseTimeOfDay(value string) (int, error) {
+	hourText, minuteText, ok := strings.Cut(value, ":")
+	hour, hourErr := strconv.Atoi(hourText)
+	minute, minuteErr := strconv.Atoi(minuteText)
+	if !ok || hourErr != nil || minuteErr != nil || hour < 0 || minute < 0 || minute > 59 || hour*60+minute > minutesPerDay {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return hour*60 + minute, nil
+}
+
+func parseDate(value string, location *time.Location) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, value, location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, expected 2006-01-02T15:04 or RFC 3339", value)
+}
+
+// Active reports whether one of the schedule's windows is open at t.
+func (s *Schedule) Active(t time.Time) bool {
+	t = t.In(s.location)
+	for _, w := range s.windows {
+		if w.open(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w window) open(t time.Time) bool {
+	if (!w.start.IsZero() && t.Before(w.start)) || (!w.end.IsZero() && !t.Before(w.end)) {
+		return false
+	}
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.from < w.to {
+		return w.days[day] && minute >= w.from && minute < w.to
+	}
+	// The window runs past midnight and belongs to the day it started on.
+	yesterday := (day + 6) % 7
+	return (w.days[day] && minute >= w.from) || (w.days[yesterday] && minute < w.to)
+}
@@ -0,0 +1,119 @@
+package acl
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/clodevo/raven-proxy/pkg/utils"
+)
+
+// scheduledACL whitelists <schedule>.example.com while the schedule of the
+// same name is active.
+const scheduledACL = `{
+	"Schedules": {
+		"overnight": {"Windows": [{"Days": ["fri"], "From": "22:00", "To": "02:00"}]},
+		"weekdays": {"Windows": [{"Days": ["weekdays"]}]},
+		"tokyo-weekdays": {"TimeZone": "Asia/Tokyo", "Windows": [{"Days": ["weekdays"]}]},
+		"berlin-night": {"TimeZone": "Europe/Berlin", "Windows": [{"From": "01:00", "To": "04:00"}]},
+		"new-york-office": {"TimeZone": "America/New_York", "Windows": [{"Days": ["weekdays"], "From": "09:00", "To": "17:00"}]},
+		"new-york-maintenance": {"TimeZone": "America/New_York", "Windows": [{"Start": "2026-11-07T22:00", "End": "2026-11-08T04:00"}]},
+		"full-day": {"Windows": [{"Days": ["mon"], "From": "10:00", "To": "10:00"}]}
+	},
+	"Whitelist": [
+		{"Pattern": "overnight.example.com", "Schedule": "overnight"},
+		{"Pattern": "weekdays.example.com", "Schedule": "weekdays"},
+		{"Pattern": "tokyo-weekdays.example.com", "Schedule": "tokyo-weekdays"},
+		{"Pattern": "berlin-night.example.com", "Schedule": "berlin-night"},
+		{"Pattern": "new-york-office.example.com", "Schedule": "new-york-office"},
+		{"Pattern": "new-york-maintenance.example.com", "Schedule": "new-york-maintenance"},
+		{"Pattern": "full-day.example.com", "Schedule": "full-day"}
+	]
+}`
+
+func TestScheduledEntries(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "acme.json"), scheduledACL)
+	a := NewACLManager(dir, utils.GetLogger())
+	var now time.Time
+	a.SetClock(func() time.Time { return now })
+	id := &utils.Identity{TenantName: "acme"}
+
+	tests := []struct {
+		name     string
+		schedule string
+		at       string // RFC 3339
+		want     bool
+	}{
+		// Windows crossing midnight belong to the day they start on.
+		{"before an overnight window", "overnight", "2026-10-16T21:59:00Z", false},
+		{"start of an overnight window", "overnight", "2026-10-16T22:00:00Z", true},
+		{"overnight window after midnight", "overnight", "2026-10-17T01:59:00Z", true},
+		{"end of an overnight window", "overnight", "2026-10-17T02:00:00Z", false},
+		{"overnight window on another day", "overnight", "2026-10-17T23:00:00Z", false},
+		{"morning of the window's own day", "overnight", "2026-10-16T01:00:00Z", false},
+
+		// Days change at midnight in the schedule's time zone.
+		{"last minute of friday", "weekdays", "2026-10-16T23:59:00Z", true},
+		{"first minute of saturday", "weekdays", "2026-10-17T00:00:00Z", false},
+		{"last minute of sunday", "weekdays", "2026-10-18T23:59:00Z", false},
+		{"first minute of monday", "weekdays", "2026-10-19T00:00:00Z", true},
+		{"friday in UTC, saturday in Tokyo", "tokyo-weekdays", "2026-10-16T15:00:00Z", false},
+		{"sunday in UTC, monday in Tokyo", "tokyo-weekdays", "2026-10-18T15:00:00Z", true},
+
+		// Berlin moves from CET to CEST at 02:00 on 2026-03-29.
+		{"before the spring gap", "berlin-night", "2026-03-29T00:30:00Z", true},
+		{"after the spring gap", "berlin-night", "2026-03-29T01:30:00Z", true},
+		{"after the window in summer time", "berlin-night", "2026-03-29T02:00:00Z", false},
+		{"before the window in winter time", "berlin-night", "2026-03-28T23:59:00Z", false},
+
+		// New York moves from EDT to EST on 2026-11-01.
+		{"opening in daylight time", "new-york-office", "2026-10-30T13:00:00Z", true},
+		{"opening hour in UTC, before opening in standard time", "new-york-office", "2026-11-02T13:30:00Z", false},
+		{"opening in standard time", "new-york-office", "2026-11-02T14:00:00Z", true},
+		{"closing in standard time", "new-york-office", "2026-11-02T22:00:00Z", false},
+		{"before a period in standard time", "new-york-maintenance", "2026-11-08T02:59:00Z", false},
+		{"start of a period in standard time", "new-york-maintenance", "2026-11-08T03:00:00Z", true},
+		{"last minute of a period", "new-york-maintenance", "2026-11-08T08:59:00Z", true},
+		{"end of a period", "new-york-maintenance", "2026-11-08T09:00:00Z", false},
+
+		// A To equal to From ends the window a day later.
+		{"before a full-day window", "full-day", "2026-10-19T09:59:00Z", false},
+		{"start of a full-day window", "full-day", "2026-10-19T10:00:00Z", true},
+		{"full-day window on the next day", "full-day", "2026-10-20T09:59:00Z", true},
+		{"end of a full-day window", "full-day", "2026-10-20T10:00:00Z", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			if now, err = time.Parse(time.RFC3339, tt.at); err != nil {
+				t.Fatal(err)
+			}
+			host := tt.schedule + ".example.com:443"
+			if got := a.IsHostAllowed(id, host, ProtocolConnect); got != tt.want {
+				t.Errorf("IsHostAllowed(%s) at %s = %v, want %v", host, tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileWindowPeriod(t *testing.T) {
+	tests := []struct {
+		name       string
+		start, end string
+		wantErr    bool
+	}{
+		{"end after start", "2026-11-07T22:00", "2026-11-08T04:00", false},
+		{"end equal to start", "2026-11-07T22:00", "2026-11-07T22:00", true},
+		{"same instant in another form", "2026-11-07", "2026-11-07T00:00", true},
+		{"end before start", "2026-11-08", "2026-11-07", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileWindow(WindowEntry{Start: tt.start, End: tt.end}, time.UTC)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("compileWindow(%s, %s) error = %v, want error %v", tt.start, tt.end, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package acl
+
+import (
+	"fmt"
+
+	"github.com/clodevo/raven-proxy/pkg/ratelimit"
+)
+
+// SchedulingList is the "Scheduling" section of a tenant ACL file. It sets
+// the tenant's weight, which sizes its guaranteed share of the proxy when
+// it is at capacity, and its priority class ("high", "normal" or "low").
+//
+//	"Scheduling": {
+//	  "Weight": 2,
+//	  "Priority": "high"
+//	}
+type SchedulingList struct {
+	Weight   float64 `json:"Weight"`
+	Priority string  `json:"Priority"`
+}
+
+// SchedulingPolicy is the compiled form of a SchedulingList.
+type SchedulingPolicy struct {
+	Weight   float64
+	Priority string
+}
+
+// defaultScheduling applies to tenants without a Scheduling section.
+var defaultScheduling = SchedulingPolicy{Weight: 1, Priority: ratelimit.PriorityNormal}
+
+func compileScheduling(list *SchedulingList) (*SchedulingPolicy, error) {
+	if list == nil {
+		return nil, nil
+	}
+
+	policy := defaultScheduling
+	if list.Weight < 0 {
+		return nil, fmt.Errorf("weight must not be negative")
+	}
+	if list.Weight > 0 {
+		policy.Weight = list.Weight
+	}
+	switch list.Priority {
+	case "":
+	case ratelimit.PriorityHigh, ratelimit.PriorityNormal, ratelimit.PriorityLow:
+		policy.Priority = list.Priority
+	default:
+		return nil, fmt.Errorf("unknown priority %q, expected high, normal or low", list.Priority)
+	}
+	return &policy, nil
+}
+
+// SchedulingFor returns the scheduling weight and priority of a tenant.
+func (a *ACLManager) SchedulingFor(tenantName string) SchedulingPolicy {
+	policy := a.Policy(tenantName)
+	if policy == nil || policy.Scheduling == nil {
+		return defaultScheduling
+	}
+	return *policy.Scheduling
+}
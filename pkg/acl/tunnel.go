@@ -0,0 +1,71 @@
+package acl
+
+import "fmt"
+
+// TunnelMode decides what happens when a CONNECT tunnel fails an inspection.
+type TunnelMode string
+
+const (
+	TunnelIgnore TunnelMode = "ignore"
+	TunnelLog    TunnelMode = "log"
+	TunnelReject TunnelMode = "reject"
+)
+
+// TunnelList is the "Tunnel" section of a tenant ACL file. It controls the
+// inspection of the first bytes of CONNECT tunnels.
+//
+//	"Tunnel": {
+//	  "SNI": "reject",
+//	  "NonTLS": "log"
+//	}
+type TunnelList struct {
+	// SNI applies when the ClientHello names a host the tenant may not reach
+	// although the CONNECT target was allowed (domain fronting).
+	SNI TunnelMode `json:"SNI"`
+	// NonTLS applies when the tunnel does not start with a TLS handshake,
+	// e.g. SSH over port 443.
+	NonTLS TunnelMode `json:"NonTLS"`
+}
+
+// TunnelPolicy is the compiled form of a TunnelList.
+type TunnelPolicy struct {
+	SNI    TunnelMode
+	NonTLS TunnelMode
+}
+
+func compileTunnel(list *TunnelList) (*TunnelPolicy, error) {
+	if list == nil {
+		return nil, nil
+	}
+	policy := &TunnelPolicy{SNI: TunnelIgnore, NonTLS: TunnelIgnore}
+	for _, field := range []struct {
+		name string
+		mode TunnelMode
+		dst  *TunnelMode
+	}{
+		{"SNI", list.SNI, &policy.SNI},
+		{"NonTLS", list.NonTLS, &policy.NonTLS},
+	} {
+		switch field.mode {
+		case "":
+		case TunnelIgnore, TunnelLog, TunnelReject:
+			*field.dst = field.mode
+		default:
+			return nil, fmt.Errorf("%s: unknown mode %q, expected ignore, log or reject", field.name, field.mode)
+		}
+	}
+	if policy.SNI == TunnelIgnore && policy.NonTLS == TunnelIgnore {
+		return nil, nil
+	}
+	return policy, nil
+}
+
+// TunnelPolicyFor returns the tunnel inspection settings of a tenant, or
+// nil when its tunnels are not inspected.
+func (a *ACLManager) TunnelPolicyFor(tenantName string) *TunnelPolicy {
+	policy := a.Policy(tenantName)
+	if policy == nil {
+		return nil
+	}
+	return policy.Tunnel
+}
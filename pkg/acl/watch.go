@@ -0,0 +1,121 @@
+package acl
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// reloadDebounce groups bursts of file events (e.g. a git pull touching
+	// many tenants) into a single reload.
+	reloadDebounce = 500 * time.Millisecond
+	// watchRetryInterval is how often Watch retries when aclDataPath cannot
+	// be watched yet, for instance before the first git clone.
+	watchRetryInterval = 10 * time.Second
+)
+
+// Watch starts a background goroutine that reloads the ACL snapshot
+// whenever a file under aclDataPath, its groups or global directory, or a
+// list file imported by a tenant, group or the global blocklist, changes.
+func (a *ACLManager) Watch() {
+	go func() {
+		for {
+			if err := a.watch(); err != nil {
+				a.logger.Info("ACL watcher on %s stopped: %v", a.aclDataPath, err)
+			}
+			time.Sleep(watchRetryInterval)
+			a.Reload()
+		}
+	}()
+}
+
+// watch runs a single fsnotify session. It returns when the watch can no
+// longer be maintained, so that Watch can set it up again.
+func (a *ACLManager) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(a.aclDataPath); err != nil {
+		return err
+	}
+	// Catch up on anything that changed while we were not watching.
+	a.Reload()
+	sources := a.watchSources(watcher)
+	a.logger.Debug("Watching ACL files in %s", a.aclDataPath)
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) == filepath.Clean(a.aclDataPath) && event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				a.Reload()
+				return nil
+			}
+			name := filepath.Clean(event.Name)
+			if strings.HasSuffix(name, ".json") || sources[name] ||
+				name == filepath.Clean(a.groupsPath()) || name == filepath.Dir(a.globalPath()) {
+				debounce = time.After(reloadDebounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			a.logger.Debug("ACL watcher error: %v", err)
+		case <-debounce:
+			debounce = nil
+			a.Reload()
+			sources = a.watchSources(watcher)
+		}
+	}
+}
+
+// watchSources adds the groups and global directories and the directories
+// of the list files imported by tenants, groups and the global blocklist
+// to the watcher, and returns the files. Directories stay watched once
+// added; events for files that are no longer imported are ignored.
+func (a *ACLManager) watchSources(watcher *fsnotify.Watcher) map[string]bool {
+	for _, dir := range []string{a.groupsPath(), filepath.Dir(a.globalPath())} {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			if err := watcher.Add(dir); err != nil {
+				a.logger.Debug("Cannot watch %s: %v", dir, err)
+			}
+		}
+	}
+
+	var policies []*Policy
+	for _, policy := range *a.policies.Load() {
+		policies = append(policies, policy)
+	}
+	if global := a.global.Load().file; global != nil {
+		policies = append(policies, global)
+	}
+
+	sources := make(map[string]bool)
+	for _, policy := range policies {
+		stamps := policy.sources
+		for _, group := range policy.groups {
+			stamps = append(stamps[:len(stamps):len(stamps)], group.sources...)
+		}
+		for _, source := range stamps {
+			path := filepath.Clean(source.path)
+			if sources[path] {
+				continue
+			}
+			sources[path] = true
+			if err := watcher.Add(filepath.Dir(path)); err != nil {
+				a.logger.Debug("Cannot watch imported list %s: %v", path, err)
+			}
+		}
+	}
+	return sources
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -15,6 +16,14 @@ type AppConfig struct {
 	ACLDataPath    string
 	AdminAddr      string
 	LogLevel       string
+	// UsageFlushInterval is how often traffic counters are written to the database.
+	UsageFlushInterval time.Duration
+	// ExceptionSyncInterval is how often expired ACL exceptions are removed
+	// and exceptions added by other instances are picked up.
+	ExceptionSyncInterval time.Duration
+	// GlobalBlockSyncInterval is how often global blocks added by other
+	// instances are picked up.
+	GlobalBlockSyncInterval time.Duration
 }
 
 func LoadAppConfig() *AppConfig {
@@ -28,6 +37,9 @@ func LoadAppConfig() *AppConfig {
 	viper.SetDefault("admin-api-key", "")
 	viper.SetDefault("admin-addr", ":9090") // Default admin server address
 	viper.SetDefault("log-Level", "info")
+	viper.SetDefault("usage-flush-interval", "30s")
+	viper.SetDefault("acl-exception-sync-interval", "30s")
+	viper.SetDefault("acl-global-sync-interval", "5s")
 
 	viper.AutomaticEnv()
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_")) // Replace dots and hyphens with underscores in env vars
@@ -39,12 +51,15 @@ func LoadAppConfig() *AppConfig {
 	}
 
 	return &AppConfig{
-		DatabaseConfig: LoadDatabaseConfig(),
-		ProxyConfig:    *LoadProxyConfig(),   // Load proxy config
-		GitSyncConfig:  *LoadGitSyncConfig(), // Load Git sync config
-		AdminAPIKey:    viper.GetString("admin-api-key"),
-		ACLDataPath:    viper.GetString("acl-data-path"),
-		AdminAddr:      viper.GetString("admin-addr"),
-		LogLevel:       viper.GetString("log-Level"),
+		DatabaseConfig:          LoadDatabaseConfig(),
+		ProxyConfig:             *LoadProxyConfig(),   // Load proxy config
+		GitSyncConfig:           *LoadGitSyncConfig(), // Load Git sync config
+		AdminAPIKey:             viper.GetString("admin-api-key"),
+		ACLDataPath:             viper.GetString("acl-data-path"),
+		AdminAddr:               viper.GetString("admin-addr"),
+		LogLevel:                viper.GetString("log-Level"),
+		UsageFlushInterval:      viper.GetDuration("usage-flush-interval"),
+		ExceptionSyncInterval:   viper.GetDuration("acl-exception-sync-interval"),
+		GlobalBlockSyncInterval: viper.GetDuration("acl-global-sync-interval"),
 	}
 }
@@ -12,6 +12,23 @@ const (
 	DefaultAddr          = ":8080"
 	DefaultDNS           = ""
 	DefaultTimeout       = 20 * time.Second
+	DefaultSOCKS5Addr    = ""
+	DefaultViaName       = "raven-proxy"
+
+	DefaultTransparentAddr = ""
+	DefaultTransparentMode = TransparentModeRedirect
+
+	DefaultTLSAddr       = ""
+	DefaultTLSMinVersion = "1.2"
+
+	DefaultSchedulerCapacity = 0
+	DefaultSchedulerMaxWait  = 5 * time.Second
+)
+
+// Modes of the transparent listener, matching the iptables target used.
+const (
+	TransparentModeRedirect = "redirect"
+	TransparentModeTProxy   = "tproxy"
 )
 
 type ProxyConfig struct {
@@ -19,6 +36,24 @@ type ProxyConfig struct {
 	MaxConcurrent int
 	DNS           []string
 	Timeout       time.Duration
+	SOCKS5Addr    string
+	ViaName       string // pseudonym of this proxy in Via headers, also used to detect loops
+
+	// Transparent proxy listener for iptables REDIRECT or TPROXY traffic.
+	TransparentAddr    string
+	TransparentMode    string   // "redirect" or "tproxy"
+	TransparentTenants []string // "CIDR=tenant" entries selecting the tenant by source address
+
+	// TLS proxy listener, for clients configured with https_proxy=https://...
+	TLSAddr         string
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSMinVersion   string // "1.0", "1.1", "1.2" or "1.3"
+	TLSClientCAFile string // when set, clients must present a certificate signed by these CAs
+
+	// Fair admission of requests across tenants; a capacity of 0 turns it off.
+	SchedulerCapacity int
+	SchedulerMaxWait  time.Duration // how long a request may queue for capacity
 }
 
 func LoadProxyConfig() *ProxyConfig {
@@ -30,6 +65,14 @@ func LoadProxyConfig() *ProxyConfig {
 	viper.SetDefault("proxy.maxConcurrent", DefaultMaxConcurrent)
 	viper.SetDefault("proxy.dns", DefaultDNS)
 	viper.SetDefault("proxy.timeout", DefaultTimeout)
+	viper.SetDefault("proxy.socks5Addr", DefaultSOCKS5Addr)
+	viper.SetDefault("proxy.viaName", DefaultViaName)
+	viper.SetDefault("proxy.transparentAddr", DefaultTransparentAddr)
+	viper.SetDefault("proxy.transparentMode", DefaultTransparentMode)
+	viper.SetDefault("proxy.tlsAddr", DefaultTLSAddr)
+	viper.SetDefault("proxy.tlsMinVersion", DefaultTLSMinVersion)
+	viper.SetDefault("proxy.schedulerCapacity", DefaultSchedulerCapacity)
+	viper.SetDefault("proxy.schedulerMaxWait", DefaultSchedulerMaxWait)
 
 	// Use Viper to retrieve values
 	config := &ProxyConfig{
@@ -37,6 +80,21 @@ func LoadProxyConfig() *ProxyConfig {
 		MaxConcurrent: viper.GetInt("proxy.maxConcurrent"),
 		DNS:           viper.GetStringSlice("proxy.dns"),
 		Timeout:       viper.GetDuration("proxy.timeout"),
+		SOCKS5Addr:    viper.GetString("proxy.socks5Addr"),
+		ViaName:       viper.GetString("proxy.viaName"),
+
+		TransparentAddr:    viper.GetString("proxy.transparentAddr"),
+		TransparentMode:    viper.GetString("proxy.transparentMode"),
+		TransparentTenants: viper.GetStringSlice("proxy.transparentTenants"),
+
+		TLSAddr:         viper.GetString("proxy.tlsAddr"),
+		TLSCertFile:     viper.GetString("proxy.tlsCertFile"),
+		TLSKeyFile:      viper.GetString("proxy.tlsKeyFile"),
+		TLSMinVersion:   viper.GetString("proxy.tlsMinVersion"),
+		TLSClientCAFile: viper.GetString("proxy.tlsClientCAFile"),
+
+		SchedulerCapacity: viper.GetInt("proxy.schedulerCapacity"),
+		SchedulerMaxWait:  viper.GetDuration("proxy.schedulerMaxWait"),
 	}
 
 	return config
@@ -75,6 +75,50 @@ func createTables(db *sql.DB) {
         FOREIGN KEY (tenant_id) REFERENCES tenants(tenant_id) ON DELETE CASCADE,
         UNIQUE(api_key, tenant_id)
     );
+    CREATE TABLE IF NOT EXISTS usage_counters (
+        tenant_id CHAR(36) NOT NULL,
+        api_key_id CHAR(36) NOT NULL,
+        day CHAR(10) NOT NULL,
+        requests BIGINT NOT NULL DEFAULT 0,
+        bytes_in BIGINT NOT NULL DEFAULT 0,
+        bytes_out BIGINT NOT NULL DEFAULT 0,
+        updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (tenant_id, api_key_id, day),
+        FOREIGN KEY (tenant_id) REFERENCES tenants(tenant_id) ON DELETE CASCADE
+    );
+    CREATE TABLE IF NOT EXISTS acl_exceptions (
+        exception_id CHAR(36) PRIMARY KEY,
+        tenant_id CHAR(36) NOT NULL,
+        action VARCHAR(5) NOT NULL,
+        pattern TEXT NOT NULL,
+        protocols TEXT NOT NULL,
+        reason TEXT NOT NULL,
+        created_by TEXT NOT NULL,
+        created_at DATETIME NOT NULL,
+        expires_at DATETIME NOT NULL,
+        FOREIGN KEY (tenant_id) REFERENCES tenants(tenant_id) ON DELETE CASCADE
+    );
+    CREATE TABLE IF NOT EXISTS acl_exception_audit (
+        audit_id CHAR(36) PRIMARY KEY,
+        exception_id CHAR(36) NOT NULL,
+        tenant_id CHAR(36) NOT NULL,
+        event VARCHAR(10) NOT NULL,
+        action VARCHAR(5) NOT NULL,
+        pattern TEXT NOT NULL,
+        protocols TEXT NOT NULL,
+        reason TEXT NOT NULL,
+        actor TEXT NOT NULL,
+        expires_at DATETIME NOT NULL,
+        at DATETIME NOT NULL
+    );
+    CREATE TABLE IF NOT EXISTS acl_global_blocks (
+        block_id CHAR(36) PRIMARY KEY,
+        pattern TEXT NOT NULL,
+        protocols TEXT NOT NULL,
+        reason TEXT NOT NULL,
+        created_by TEXT NOT NULL,
+        created_at DATETIME NOT NULL
+    );
     `
 	_, err := db.Exec(sqlStmt)
 	if err != nil {
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/clodevo/raven-proxy/pkg/acl"
+	"github.com/clodevo/raven-proxy/pkg/database"
+	"github.com/clodevo/raven-proxy/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var aclManager *acl.ACLManager // ACL manager of the proxy, for handlers that report on tenant policies
+
+// SetACLManager makes the proxy's ACL manager available to the handlers.
+func SetACLManager(manager *acl.ACLManager) {
+	aclManager = manager
+}
+
+// lookupTenant resolves the tenantID path parameter to the tenant's name,
+// which names its ACL file. It writes the error response and returns false
+// when the ID is invalid or unknown.
+func lookupTenant(c *gin.Context) (uuid.UUID, string, bool) {
+	tenantID, err := uuid.Parse(c.Param("tenantID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tenant ID"})
+		return uuid.UUID{}, "", false
+	}
+
+	var tenantName string
+	err = database.DB.QueryRow("SELECT tenant_name FROM tenants WHERE tenant_id = ?", tenantID).Scan(&tenantName)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Tenant not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error: " + err.Error()})
+		}
+		return uuid.UUID{}, "", false
+	}
+	return tenantID, tenantName, true
+}
+
+// @Summary List imported lists
+// @Description List the external list files imported by a tenant's ACL file and its policy groups, as of their last successful load, with the number of domains imported and the lines that were unsupported or could not be parsed
+// @Tags tenants
+// @Produce json
+// @Param tenantID path string true "Tenant ID"
+// @Success 200 {array} models.ImportReport
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /tenants/{tenantID}/imports [get]
+// @Security ApiKeyAuth
+func GetTenantImports(c *gin.Context) {
+	_, tenantName, ok := lookupTenant(c)
+	if !ok {
+		return
+	}
+
+	reports := []models.ImportReport{}
+	if aclManager != nil {
+		for _, report := range aclManager.ImportsFor(tenantName) {
+			reports = append(reports, models.ImportReport{
+				Path:     report.Path,
+				Group:    report.Group,
+				Format:   report.Format,
+				Into:     report.Into,
+				Entries:  report.Entries,
+				Skipped:  report.Skipped,
+				Invalid:  report.Invalid,
+				Errors:   report.Errors,
+				LoadedAt: report.LoadedAt,
+			})
+		}
+	}
+	c.JSON(http.StatusOK, reports)
+}
+
+// exceptionModel converts an exception for the API.
+func exceptionModel(e *acl.Exception) models.ACLException {
+	return models.ACLException{
+		ID:        e.ID,
+		TenantID:  e.TenantID,
+		Action:    e.Action,
+		Pattern:   e.Pattern,
+		Protocols: e.Protocols,
+		Reason:    e.Reason,
+		CreatedBy: e.CreatedBy,
+		CreatedAt: e.CreatedAt,
+		ExpiresAt: e.ExpiresAt,
+	}
+}
+
+// @Summary List ACL exceptions
+// @Description List the temporary allow and deny exceptions of a tenant that are in force
+// @Tags tenants
+// @Produce json
+// @Param tenantID path string true "Tenant ID"
+// @Success 200 {array} models.ACLException
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /tenants/{tenantID}/exceptions [get]
+// @Security ApiKeyAuth
+func GetTenantExceptions(c *gin.Context) {
+	_, tenantName, ok := lookupTenant(c)
+	if !ok {
+		return
+	}
+
+	exceptions := []models.ACLException{}
+	if aclManager != nil {
+		for _, e := range aclManager.ExceptionsFor(tenantName) {
+			exceptions = append(exceptions, exceptionModel(e))
+		}
+	}
+	c.JSON(http.StatusOK, exceptions)
+}
+
+// @Summary Add an ACL exception
+// @Description Add a temporary allow or deny exception for a tenant. The pattern takes the same forms as Whitelist and Blacklist entries. Exceptions take precedence over the tenant's ACL file, deny before allow, and are removed automatically once they expire
+// @Tags tenants
+// @Accept json
+// @Produce json
+// @Param tenantID path string true "Tenant ID"
+// @Param body body models.ACLExceptionRequest true "Exception"
+// @Success 201 {object} models.ACLException
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /tenants/{tenantID}/exceptions [post]
+// @Security ApiKeyAuth
+func CreateTenantException(c *gin.Context) {
+	tenantID, tenantName, ok := lookupTenant(c)
+	if !ok {
+		return
+	}
+
+	var req models.ACLExceptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request: " + err.Error()})
+		return
+	}
+	if aclManager == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error: ACL manager not available"})
+		return
+	}
+
+	exception := &acl.Exception{
+		TenantID:   tenantID.String(),
+		TenantName: tenantName,
+		Action:     req.Action,
+		Pattern:    req.Pattern,
+		Protocols:  req.Protocols,
+		Reason:     req.Reason,
+		CreatedBy:  req.CreatedBy,
+		ExpiresAt:  req.ExpiresAt,
+	}
+	if err := aclManager.AddException(exception); err != nil {
+		if errors.Is(err, acl.ErrInvalidException) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error: " + err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, exceptionModel(exception))
+}
+
+// @Summary Revoke an ACL exception
+// @Description Remove a tenant's exception before it expires. The revocation is recorded in the audit trail
+// @Tags tenants
+// @Produce json
+// @Param tenantID path string true "Tenant ID"
+// @Param exceptionID path string true "Exception ID"
+// @Param revoked_by query string false "Who revokes the exception, for the audit trail"
+// @Success 200 {string} string "Exception revoked successfully"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /tenants/{tenantID}/exceptions/{exceptionID} [delete]
+// @Security ApiKeyAuth
+func RevokeTenantException(c *gin.Context) {
+	tenantID, _, ok := lookupTenant(c)
+	if !ok {
+		return
+	}
+	exceptionID, err := uuid.Parse(c.Param("exceptionID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid exception ID"})
+		return
+	}
+	if aclManager == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error: ACL manager not available"})
+		return
+	}
+
+	if err := aclManager.RevokeException(tenantID.String(), exceptionID.String(), c.Query("revoked_by")); err != nil {
+		if errors.Is(err, acl.ErrExceptionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Exception not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error: " + err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Exception revoked successfully"})
+}
+
+// @Summary Get the ACL exception audit trail
+// @Description List when each of a tenant's exceptions was created, revoked or expired, oldest first
+// @Tags tenants
+// @Produce json
+// @Param tenantID path string true "Tenant ID"
+// @Success 200 {array} models.ACLExceptionAudit
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /tenants/{tenantID}/exceptions/audit [get]
+// @Security ApiKeyAuth
+func GetTenantExceptionAudit(c *gin.Context) {
+	tenantID, _, ok := lookupTenant(c)
+	if !ok {
+		return
+	}
+
+	records, err := acl.ExceptionAuditFor(tenantID.String())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error: " + err.Error()})
+		return
+	}
+	audit := make([]models.ACLExceptionAudit, 0, len(records))
+	for _, r := range records {
+		audit = append(audit, models.ACLExceptionAudit{
+			ID:          r.ID,
+			ExceptionID: r.ExceptionID,
+			Event:       r.Event,
+			Action:      r.Action,
+			Pattern:     r.Pattern,
+			Protocols:   r.Protocols,
+			Reason:      r.Reason,
+			Actor:       r.Actor,
+			ExpiresAt:   r.ExpiresAt,
+			At:          r.At,
+		})
+	}
+	c.JSON(http.StatusOK, audit)
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/clodevo/raven-proxy/pkg/acl"
+	"github.com/clodevo/raven-proxy/pkg/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// globalBlockModel converts a global blocklist entry for the API.
+func globalBlockModel(b *acl.GlobalBlock) models.GlobalBlock {
+	block := models.GlobalBlock{
+		ID:        b.ID,
+		Source:    b.Source,
+		Pattern:   b.Pattern,
+		Protocols: b.Protocols,
+		Reason:    b.Reason,
+		CreatedBy: b.CreatedBy,
+	}
+	if !b.CreatedAt.IsZero() {
+		createdAt := b.CreatedAt
+		block.CreatedAt = &createdAt
+	}
+	return block
+}
+
+// @Summary List the global blocklist
+// @Description List the destinations blocked for every tenant: the Blacklist entries of the global blocklist file, without imported lists, then the blocks added through the API
+// @Tags blocklist
+// @Produce json
+// @Success 200 {array} models.GlobalBlock
+// @Router /blocklist [get]
+// @Security ApiKeyAuth
+func GetGlobalBlocklist(c *gin.Context) {
+	blocks := []models.GlobalBlock{}
+	if aclManager != nil {
+		for _, b := range aclManager.GlobalBlocks() {
+			blocks = append(blocks, globalBlockModel(b))
+		}
+	}
+	c.JSON(http.StatusOK, blocks)
+}
+
+// @Summary Block a destination for every tenant
+// @Description Add an entry to the global blocklist. The pattern takes the same forms as Blacklist entries. Global blocks are checked before any tenant rule or exception, and reach every proxy instance sharing the database within acl-global-sync-interval
+// @Tags blocklist
+// @Accept json
+// @Produce json
+// @Param body body models.GlobalBlockRequest true "Global block"
+// @Success 201 {object} models.GlobalBlock
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /blocklist [post]
+// @Security ApiKeyAuth
+func CreateGlobalBlock(c *gin.Context) {
+	var req models.GlobalBlockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request: " + err.Error()})
+		return
+	}
+	if aclManager == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error: ACL manager not available"})
+		return
+	}
+
+	block := &acl.GlobalBlock{
+		Pattern:   req.Pattern,
+		Protocols: req.Protocols,
+		Reason:    req.Reason,
+		CreatedBy: req.CreatedBy,
+	}
+	if err := aclManager.AddGlobalBlock(block); err != nil {
+		if errors.Is(err, acl.ErrInvalidGlobalBlock) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error: " + err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, globalBlockModel(block))
+}
+
+// @Summary Remove a global block
+// @Description Remove an entry added to the global blocklist through the API. Entries of the global blocklist file are removed by editing the file
+// @Tags blocklist
+// @Produce json
+// @Param blockID path string true "Block ID"
+// @Param removed_by query string false "Who removes the block, for the logs"
+// @Success 200 {string} string "Global block removed successfully"
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /blocklist/{blockID} [delete]
+// @Security ApiKeyAuth
+func RemoveGlobalBlock(c *gin.Context) {
+	blockID, err := uuid.Parse(c.Param("blockID"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid block ID"})
+		return
+	}
+	if aclManager == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error: ACL manager not available"})
+		return
+	}
+
+	if err := aclManager.RemoveGlobalBlock(blockID.String(), c.Query("removed_by")); err != nil {
+		if errors.Is(err, acl.ErrGlobalBlockNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Global block not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error: " + err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Global block removed successfully"})
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/clodevo/raven-proxy/pkg/acl"
+	"github.com/clodevo/raven-proxy/pkg/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Decisions in explanations and expected decisions in batches.
+const (
+	decisionAllow = "allow"
+	decisionBlock = "block"
+)
+
+func decisionOf(allowed bool) string {
+	if allowed {
+		return decisionAllow
+	}
+	return decisionBlock
+}
+
+// stepModel converts a step of an explanation for the API.
+func stepModel(step acl.Step) models.ACLStep {
+	s := models.ACLStep{
+		Layer:   step.Layer,
+		Group:   step.Group,
+		List:    step.List,
+		Action:  decisionOf(step.Allowed),
+		Matched: step.Rule != nil,
+		Detail:  step.Detail,
+	}
+	if step.Rule != nil {
+		s.Rule = &models.ACLRuleMatch{
+			Pattern:   step.Rule.Pattern,
+			Kind:      step.Rule.Kind,
+			Matcher:   step.Rule.Matcher(),
+			Protocols: step.Rule.Protocols,
+		}
+		if step.Rule.Schedule != nil {
+			s.Rule.Schedule = step.Rule.Schedule.Name
+		}
+		if step.Block != nil {
+			s.Rule.BlockID, s.Rule.Reason = step.Block.ID, step.Block.Reason
+		}
+		if step.Exception != nil {
+			s.Rule.ExceptionID, s.Rule.Reason = step.Exception.ID, step.Exception.Reason
+		}
+	}
+	return s
+}
+
+// explanationModel converts an explanation for the API.
+func explanationModel(e *acl.Explanation) models.ACLExplanation {
+	explanation := models.ACLExplanation{
+		Tenant:    e.Tenant,
+		Host:      e.Host,
+		Port:      e.Port,
+		Protocol:  e.Protocol,
+		Decision:  decisionOf(e.Allowed),
+		DecidedBy: stepModel(e.Decision),
+		Steps:     make([]models.ACLStep, 0, len(e.Steps)),
+	}
+	for _, step := range e.Steps {
+		explanation.Steps = append(explanation.Steps, stepModel(step))
+	}
+	return explanation
+}
+
+// validScheme reports whether scheme is empty or a URI scheme the proxy serves.
+func validScheme(scheme string) bool {
+	return scheme == "" || scheme == "http" || scheme == "https"
+}
+
+// validPort reports whether port is empty or a port number.
+func validPort(port string) bool {
+	if port == "" {
+		return true
+	}
+	p, err := strconv.Atoi(port)
+	return err == nil && p > 0 && p <= 65535
+}
+
+// @Summary Explain an ACL decision
+// @Description Decide whether a tenant may reach a destination, as the proxy would, and explain why: the layer, list and pattern that decided, every layer consulted before it, and how each matching pattern was compiled. Nothing is sent to the destination
+// @Tags tenants
+// @Produce json
+// @Param tenantID path string true "Tenant ID"
+// @Param host query string true "Destination host name or IP address"
+// @Param port query string false "Destination port; defaults to 443 for CONNECT, and otherwise to that of the scheme"
+// @Param method query string false "HTTP method of the request; CONNECT is evaluated as a tunnel, anything else as a plain HTTP request"
+// @Param scheme query string false "URI scheme of a plain HTTP request, http (the default) or https, which sets the default port to 80 or 443" Enums(http, https)
+// @Success 200 {object} models.ACLExplanation
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /tenants/{tenantID}/explain [get]
+// @Security ApiKeyAuth
+func ExplainTenantDecision(c *gin.Context) {
+	_, tenantName, ok := lookupTenant(c)
+	if !ok {
+		return
+	}
+	host, port := c.Query("host"), c.Query("port")
+	if host == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request: host is required"})
+		return
+	}
+	if !validPort(port) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request: invalid port " + port})
+		return
+	}
+	scheme := c.Query("scheme")
+	if !validScheme(scheme) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request: invalid scheme " + scheme + ", expected http or https"})
+		return
+	}
+	if aclManager == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error: ACL manager not available"})
+		return
+	}
+
+	c.JSON(http.StatusOK, explanationModel(aclManager.Explain(tenantName, host, port, c.Query("method"), scheme, nil)))
+}
+
+// @Summary Explain ACL decisions in a batch
+// @Description Decide on a batch of destinations and compare each decision with the one expected, for instance to test ACL changes in CI before merging them. Tenants are named as their ACL files. The lists object optionally maps tenant names to the content of candidate ACL files, which are used instead of the loaded ones for this batch only. The response passes when every case with an expected decision gets it
+// @Tags tenants
+// @Accept json
+// @Produce json
+// @Param body body models.ACLExplainBatchRequest true "Cases to decide on"
+// @Success 200 {object} models.ACLExplainBatchResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /explain [post]
+// @Security ApiKeyAuth
+func ExplainDecisions(c *gin.Context) {
+	var req models.ACLExplainBatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request: " + err.Error()})
+		return
+	}
+	for i, tc := range req.Cases {
+		if !validPort(tc.Port) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request: case " + strconv.Itoa(i+1) + ": invalid port " + tc.Port})
+			return
+		}
+	}
+	if aclManager == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error: ACL manager not available"})
+		return
+	}
+
+	candidates := make(map[string]*acl.Policy, len(req.Lists))
+	for tenantName, content := range req.Lists {
+		policy, err := aclManager.CompileList(tenantName, content)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Bad request: list of tenant " + tenantName + ": " + err.Error()})
+			return
+		}
+		candidates[tenantName] = policy
+	}
+
+	response := models.ACLExplainBatchResponse{
+		Passed:  true,
+		Total:   len(req.Cases),
+		Results: make([]models.ACLExplainResult, 0, len(req.Cases)),
+	}
+	for _, tc := range req.Cases {
+		explanation := explanationModel(aclManager.Explain(tc.Tenant, tc.Host, tc.Port, tc.Method, tc.Scheme, candidates))
+		result := models.ACLExplainResult{ACLExplainCase: tc, Explanation: explanation}
+		if tc.Expect != "" {
+			passed := tc.Expect == explanation.Decision
+			result.Passed = &passed
+			if !passed {
+				response.Passed = false
+				response.Failed++
+			}
+		}
+		response.Results = append(response.Results, result)
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/clodevo/raven-proxy/pkg/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary List rate limit buckets
+// @Description List the token buckets of the tenants and API keys that have sent traffic recently, with their remaining tokens and how many operations were throttled
+// @Tags rate-limits
+// @Produce json
+// @Param tenant query string false "Only show the buckets of this tenant name"
+// @Success 200 {array} models.RateLimitBucket
+// @Router /rate-limits [get]
+// @Security ApiKeyAuth
+func GetRateLimits(c *gin.Context) {
+	c.JSON(http.StatusOK, ratelimit.GetLimiter().Snapshot(c.Query("tenant")))
+}
+
+// @Summary List bandwidth throttles
+// @Description List the upload and download throttles of the tenants that have sent traffic, with their caps, burst allowance left and current throughput
+// @Tags rate-limits
+// @Produce json
+// @Param tenant query string false "Only show the throttles of this tenant name"
+// @Success 200 {array} models.BandwidthThrottle
+// @Router /bandwidth [get]
+// @Security ApiKeyAuth
+func GetBandwidth(c *gin.Context) {
+	c.JSON(http.StatusOK, ratelimit.GetLimiter().BandwidthSnapshot(c.Query("tenant")))
+}
+
+// @Summary List concurrency caps
+// @Description List the tunnels and HTTP requests that tenants and API keys have open right now, against their caps, and how many were refused for hitting a cap. A max of 0 means unlimited
+// @Tags rate-limits
+// @Produce json
+// @Param tenant query string false "Only show the caps of this tenant name"
+// @Success 200 {array} models.ConcurrencySlot
+// @Router /concurrency [get]
+// @Security ApiKeyAuth
+func GetConcurrency(c *gin.Context) {
+	c.JSON(http.StatusOK, ratelimit.GetLimiter().Occupancy(c.Query("tenant")))
+}
+
+// @Summary Get scheduler state
+// @Description Get the capacity of the fair admission scheduler, the requests in service and waiting, and per tenant its weight, priority, current guaranteed share and how many requests were admitted or timed out in the queue
+// @Tags rate-limits
+// @Produce json
+// @Success 200 {object} models.SchedulerState
+// @Router /scheduler [get]
+// @Security ApiKeyAuth
+func GetScheduler(c *gin.Context) {
+	c.JSON(http.StatusOK, ratelimit.GetScheduler().State())
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/clodevo/raven-proxy/pkg/acl"
+	"github.com/clodevo/raven-proxy/pkg/models"
+	"github.com/clodevo/raven-proxy/pkg/usage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// @Summary Get tenant usage
+// @Description Get the requests and bytes a tenant has used in the current period, in total and per API key, and the quota they count against. The period is the quota's, or the one given, defaulting to daily. API key "" holds traffic without a key
+// @Tags tenants
+// @Produce json
+// @Param tenantID path string true "Tenant ID"
+// @Param period query string false "daily or monthly, when the tenant has no quota"
+// @Success 200 {object} models.TenantUsage
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /tenants/{tenantID}/usage [get]
+// @Security ApiKeyAuth
+func GetTenantUsage(c *gin.Context) {
+	tenantID, tenantName, ok := lookupTenant(c)
+	if !ok {
+		return
+	}
+
+	var quota *acl.QuotaPolicy
+	if aclManager != nil {
+		quota = aclManager.QuotaFor(tenantName)
+	}
+	period := c.DefaultQuery("period", usage.PeriodDaily)
+	if quota != nil {
+		period = quota.Period
+	} else if period != usage.PeriodDaily && period != usage.PeriodMonthly {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid period, expected daily or monthly"})
+		return
+	}
+
+	meter := usage.GetMeter()
+	totals, err := meter.Usage(tenantID.String(), period)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error: " + err.Error()})
+		return
+	}
+	keys, err := meter.KeyUsage(tenantID.String(), period)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error: " + err.Error()})
+		return
+	}
+
+	start, end := usage.PeriodBounds(period, time.Now())
+	report := models.TenantUsage{
+		TenantID:    tenantID.String(),
+		TenantName:  tenantName,
+		Period:      period,
+		PeriodStart: start,
+		ResetsAt:    end,
+		Requests:    totals.Requests,
+		BytesIn:     totals.BytesIn,
+		BytesOut:    totals.BytesOut,
+		Keys:        make(map[string]models.KeyUsage, len(keys)),
+	}
+	for apiKeyID, key := range keys {
+		report.Keys[apiKeyID] = models.KeyUsage{Requests: key.Requests, BytesIn: key.BytesIn, BytesOut: key.BytesOut}
+	}
+	if quota != nil {
+		report.Quota = &models.Quota{
+			Requests:               quota.Requests,
+			Bytes:                  quota.Bytes,
+			Action:                 quota.Action,
+			ThrottleBytesPerSecond: quota.Throttle.Rate,
+		}
+		report.Exhausted = quota.Exhausted(totals)
+	}
+
+	c.JSON(http.StatusOK, report)
+}
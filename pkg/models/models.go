@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -36,3 +37,223 @@ type AuthResponse struct {
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
+
+// RateLimitBucket represents the state of a rate limit bucket
+type RateLimitBucket struct {
+	Tenant    string    `json:"tenant"`
+	APIKeyID  string    `json:"api_key_id,omitempty"`
+	Kind      string    `json:"kind"`
+	Rate      float64   `json:"rate"`
+	Burst     int       `json:"burst"`
+	Tokens    float64   `json:"tokens"`
+	Allowed   uint64    `json:"allowed"`
+	Throttled uint64    `json:"throttled"`
+	LastUsed  time.Time `json:"last_used"`
+}
+
+// BandwidthThrottle represents the state of a tenant's bandwidth throttle in one direction
+type BandwidthThrottle struct {
+	Tenant         string  `json:"tenant"`
+	Direction      string  `json:"direction"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+	BurstBytes     int     `json:"burst_bytes"`
+	TokensBytes    float64 `json:"tokens_bytes"`
+	CurrentRate    float64 `json:"current_bytes_per_second"`
+	TotalBytes     uint64  `json:"total_bytes"`
+}
+
+// TenantUsage represents a tenant's traffic in the current quota period and the quota it counts against
+type TenantUsage struct {
+	TenantID    string              `json:"tenant_id"`
+	TenantName  string              `json:"tenant_name"`
+	Period      string              `json:"period"`
+	PeriodStart time.Time           `json:"period_start"`
+	ResetsAt    time.Time           `json:"resets_at"`
+	Requests    uint64              `json:"requests"`
+	BytesIn     uint64              `json:"bytes_in"`
+	BytesOut    uint64              `json:"bytes_out"`
+	Quota       *Quota              `json:"quota,omitempty"`
+	Exhausted   bool                `json:"exhausted"`
+	Keys        map[string]KeyUsage `json:"keys"`
+}
+
+// ImportReport represents the outcome of loading a list file imported by a tenant's ACL file
+type ImportReport struct {
+	Path     string    `json:"path"`
+	Group    string    `json:"group,omitempty"`
+	Format   string    `json:"format"`
+	Into     string    `json:"into"`
+	Entries  int       `json:"entries"`
+	Skipped  int       `json:"skipped"`
+	Invalid  int       `json:"invalid"`
+	Errors   []string  `json:"errors,omitempty"`
+	LoadedAt time.Time `json:"loaded_at"`
+}
+
+// ACLExceptionRequest represents a request to add a temporary ACL exception for a tenant
+type ACLExceptionRequest struct {
+	Action    string    `json:"action" binding:"required"`
+	Pattern   string    `json:"pattern" binding:"required"`
+	Protocols []string  `json:"protocols,omitempty"`
+	Reason    string    `json:"reason" binding:"required"`
+	ExpiresAt time.Time `json:"expires_at" binding:"required"`
+	CreatedBy string    `json:"created_by,omitempty"`
+}
+
+// ACLException represents a temporary allow or deny exception of a tenant
+type ACLException struct {
+	ID        string    `json:"exception_id"`
+	TenantID  string    `json:"tenant_id"`
+	Action    string    `json:"action"`
+	Pattern   string    `json:"pattern"`
+	Protocols []string  `json:"protocols,omitempty"`
+	Reason    string    `json:"reason"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ACLExceptionAudit represents an exception being created, revoked or expiring
+type ACLExceptionAudit struct {
+	ID          string    `json:"audit_id"`
+	ExceptionID string    `json:"exception_id"`
+	Event       string    `json:"event"`
+	Action      string    `json:"action"`
+	Pattern     string    `json:"pattern"`
+	Protocols   []string  `json:"protocols,omitempty"`
+	Reason      string    `json:"reason"`
+	Actor       string    `json:"actor,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	At          time.Time `json:"at"`
+}
+
+// GlobalBlockRequest represents a request to block a destination for every tenant
+type GlobalBlockRequest struct {
+	Pattern   string   `json:"pattern" binding:"required"`
+	Protocols []string `json:"protocols,omitempty"`
+	Reason    string   `json:"reason" binding:"required"`
+	CreatedBy string   `json:"created_by,omitempty"`
+}
+
+// GlobalBlock represents an entry of the global blocklist, from the global file or added through the API
+type GlobalBlock struct {
+	ID        string     `json:"block_id,omitempty"`
+	Source    string     `json:"source"`
+	Pattern   string     `json:"pattern"`
+	Protocols []string   `json:"protocols,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	CreatedBy string     `json:"created_by,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// ACLRuleMatch represents the rule that matched a destination in an ACL layer
+type ACLRuleMatch struct {
+	Pattern     string   `json:"pattern"`
+	Kind        string   `json:"kind"`
+	Matcher     string   `json:"matcher"`
+	Protocols   []string `json:"protocols,omitempty"`
+	Schedule    string   `json:"schedule,omitempty"`
+	BlockID     string   `json:"block_id,omitempty"`
+	ExceptionID string   `json:"exception_id,omitempty"`
+	Reason      string   `json:"reason,omitempty"`
+}
+
+// ACLStep represents a list consulted while deciding on a destination
+type ACLStep struct {
+	Layer   string        `json:"layer"`
+	Group   string        `json:"group,omitempty"`
+	List    string        `json:"list,omitempty"`
+	Action  string        `json:"action"`
+	Matched bool          `json:"matched"`
+	Rule    *ACLRuleMatch `json:"rule,omitempty"`
+	Detail  string        `json:"detail,omitempty"`
+}
+
+// ACLExplanation represents how a destination is decided for a tenant
+type ACLExplanation struct {
+	Tenant    string    `json:"tenant"`
+	Host      string    `json:"host"`
+	Port      string    `json:"port"`
+	Protocol  string    `json:"protocol"`
+	Decision  string    `json:"decision"`
+	DecidedBy ACLStep   `json:"decided_by"`
+	Steps     []ACLStep `json:"steps"`
+}
+
+// ACLExplainCase represents a destination to decide on in a batch, with the decision expected
+type ACLExplainCase struct {
+	Tenant string `json:"tenant" binding:"required"`
+	Host   string `json:"host" binding:"required"`
+	Port   string `json:"port,omitempty"`
+	Method string `json:"method,omitempty"`
+	Scheme string `json:"scheme,omitempty" binding:"omitempty,oneof=http https"`
+	Expect string `json:"expect,omitempty" binding:"omitempty,oneof=allow block"`
+}
+
+// ACLExplainBatchRequest represents a batch of destinations to decide on, optionally against candidate ACL files
+type ACLExplainBatchRequest struct {
+	Lists map[string]json.RawMessage `json:"lists,omitempty" swaggertype:"object"`
+	Cases []ACLExplainCase           `json:"cases" binding:"required,dive"`
+}
+
+// ACLExplainResult represents the decision on a destination of a batch
+type ACLExplainResult struct {
+	ACLExplainCase
+	Passed      *bool          `json:"passed,omitempty"`
+	Explanation ACLExplanation `json:"explanation"`
+}
+
+// ACLExplainBatchResponse represents the decisions on a batch of destinations
+type ACLExplainBatchResponse struct {
+	Passed  bool               `json:"passed"`
+	Total   int                `json:"total"`
+	Failed  int                `json:"failed"`
+	Results []ACLExplainResult `json:"results"`
+}
+
+// Quota represents a tenant's traffic quota
+type Quota struct {
+	Requests               uint64  `json:"requests,omitempty"`
+	Bytes                  uint64  `json:"bytes,omitempty"`
+	Action                 string  `json:"action"`
+	ThrottleBytesPerSecond float64 `json:"throttle_bytes_per_second,omitempty"`
+}
+
+// KeyUsage represents the traffic of one API key in the current quota period
+type KeyUsage struct {
+	Requests uint64 `json:"requests"`
+	BytesIn  uint64 `json:"bytes_in"`
+	BytesOut uint64 `json:"bytes_out"`
+}
+
+// ConcurrencySlot represents the occupancy of a cap on simultaneously open tunnels or in-flight requests
+type ConcurrencySlot struct {
+	Tenant   string    `json:"tenant"`
+	APIKeyID string    `json:"api_key_id,omitempty"`
+	Kind     string    `json:"kind"`
+	Max      int       `json:"max"`
+	Open     int       `json:"open"`
+	Rejected uint64    `json:"rejected"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// SchedulerState represents the occupancy of the fair admission scheduler
+type SchedulerState struct {
+	Capacity int               `json:"capacity"`
+	MaxWait  string            `json:"max_wait"`
+	InUse    int               `json:"in_use"`
+	Waiting  int               `json:"waiting"`
+	Tenants  []SchedulerTenant `json:"tenants"`
+}
+
+// SchedulerTenant represents a tenant's requests in the fair admission scheduler
+type SchedulerTenant struct {
+	Tenant   string  `json:"tenant"`
+	Weight   float64 `json:"weight"`
+	Priority string  `json:"priority"`
+	Share    float64 `json:"share"`
+	InUse    int     `json:"in_use"`
+	Waiting  int     `json:"waiting"`
+	Admitted uint64  `json:"admitted"`
+	TimedOut uint64  `json:"timed_out"`
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/clodevo/raven-proxy/pkg/acl"
+	"github.com/clodevo/raven-proxy/pkg/config"
+	"github.com/clodevo/raven-proxy/pkg/resolver"
+	"github.com/clodevo/raven-proxy/pkg/utils"
+)
+
+var dnsResolver = resolver.New(nil, utils.GetLogger())
+
+// ConfigureResolver makes every dial of the proxy resolve names through the
+// DNS servers of cfg. It must be called before the listeners are started.
+func ConfigureResolver(cfg *config.ProxyConfig) {
+	dnsResolver = resolver.New(cfg.DNS, utils.GetLogger())
+	dnsResolver.LogStats(time.Minute)
+}
+
+// dialDirect resolves addr with dnsResolver and connects to the first
+// address that answers. Unless addresses is nil, every address is checked
+// against it right before it is dialed, so the check covers exactly the
+// address connected to and a name cannot be rebound to an internal address
+// in between.
+func dialDirect(addr string, timeout time.Duration, addresses *acl.AddressPolicy) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := lookupIP(host, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	var lastErr error
+	for _, ip := range ips {
+		if addresses != nil {
+			if err := addresses.Check(ip, port); err != nil {
+				lastErr = fmt.Errorf("connecting to %s: %w", host, err)
+				continue
+			}
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// lookupIP resolves host with dnsResolver.
+func lookupIP(host string, timeout time.Duration) ([]net.IP, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ips, err := dnsResolver.LookupIP(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", host, err)
+	}
+	return ips, nil
+}
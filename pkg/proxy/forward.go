@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"bytes"
+	"net"
+	"strings"
+
+	"github.com/clodevo/raven-proxy/pkg/acl"
+	"github.com/clodevo/raven-proxy/pkg/utils"
+	"github.com/valyala/fasthttp"
+)
+
+// hopByHopHeaders apply to a single connection and must not be forwarded
+// (RFC 9110, section 7.6.1). Proxy-Authorization carries the tenant's API
+// key and is never meant for the origin. Transfer-Encoding is left to
+// fasthttp, which re-frames bodies on its own.
+var hopByHopHeaders = []string{
+	fasthttp.HeaderConnection,
+	"Keep-Alive",
+	"Proxy-Connection",
+	fasthttp.HeaderProxyAuthenticate,
+	fasthttp.HeaderProxyAuthorization,
+	fasthttp.HeaderTE,
+	fasthttp.HeaderTrailer,
+	fasthttp.HeaderUpgrade,
+}
+
+// headerDeleter is implemented by both fasthttp.RequestHeader and fasthttp.ResponseHeader.
+type headerDeleter interface {
+	Peek(key string) []byte
+	Del(key string)
+}
+
+// stripHopByHop removes the hop-by-hop headers, including the ones the
+// sender listed in Connection.
+func stripHopByHop(header headerDeleter) {
+	for _, name := range bytes.Split(header.Peek(fasthttp.HeaderConnection), []byte(",")) {
+		if name = bytes.TrimSpace(name); len(name) > 0 {
+			header.Del(string(name))
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		header.Del(name)
+	}
+}
+
+// loopHeader carries the loop tokens of the proxies a request went
+// through. Unlike Via, it is added whatever the tenant's forwarding
+// settings, so that loops are detected for every tenant.
+const loopHeader = "X-Raven-Loop"
+
+// loopToken identifies this proxy process in loopHeader. It is random, so
+// that it tells the origin nothing about the proxy or the tenant.
+var loopToken = utils.GenerateRandomString(16)
+
+// isLoop reports whether the request already went through this proxy,
+// according to its loop tokens or its Via header.
+func isLoop(req *fasthttp.Request, viaName string) bool {
+	for _, tokens := range req.Header.PeekAll(loopHeader) {
+		for _, token := range strings.Split(string(tokens), ",") {
+			if strings.TrimSpace(token) == loopToken {
+				return true
+			}
+		}
+	}
+	for _, via := range req.Header.PeekAll(fasthttp.HeaderVia) {
+		for _, entry := range strings.Split(string(via), ",") {
+			// Each entry is "protocol received-by [comment]".
+			fields := strings.Fields(entry)
+			if len(fields) >= 2 && strings.EqualFold(fields[1], viaName) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// viaEntry returns this proxy's Via entry for a message of the given protocol.
+func viaEntry(protocol []byte, viaName string) string {
+	version := strings.TrimPrefix(string(protocol), "HTTP/")
+	if version == "" {
+		version = "1.1"
+	}
+	return version + " " + viaName
+}
+
+// prepareRequest turns the client's request into the request sent to the
+// origin, according to the tenant's forwarding settings.
+func prepareRequest(req *fasthttp.Request, id *utils.Identity, fwd *acl.ForwardingPolicy, viaName string) {
+	stripHopByHop(&req.Header)
+
+	req.Header.Add(loopHeader, loopToken)
+	if fwd.Via {
+		req.Header.Add(fasthttp.HeaderVia, viaEntry(req.Header.Protocol(), viaName))
+	}
+
+	clientIP, _, err := net.SplitHostPort(id.ClientAddr)
+	if err != nil {
+		clientIP = id.ClientAddr
+	}
+	if fwd.XForwardedFor {
+		if previous := req.Header.Peek(fasthttp.HeaderXForwardedFor); len(previous) > 0 {
+			req.Header.Set(fasthttp.HeaderXForwardedFor, string(previous)+", "+clientIP)
+		} else {
+			req.Header.Set(fasthttp.HeaderXForwardedFor, clientIP)
+		}
+	}
+	if fwd.Forwarded {
+		node := clientIP
+		if strings.Contains(node, ":") {
+			node = `"[` + node + `]"`
+		}
+		element := "for=" + node + ";proto=" + string(req.URI().Scheme())
+		if host := req.Host(); len(host) > 0 {
+			element += `;host="` + string(host) + `"`
+		}
+		req.Header.Add(fasthttp.HeaderForwarded, element)
+	}
+}
+
+// prepareResponse cleans up the origin's response before it is relayed to the client.
+func prepareResponse(resp *fasthttp.Response, fwd *acl.ForwardingPolicy, viaName string) {
+	stripHopByHop(&resp.Header)
+	resp.Header.Del(loopHeader)
+	if fwd.Via {
+		resp.Header.Add(fasthttp.HeaderVia, viaEntry(resp.Header.Protocol(), viaName))
+	}
+}
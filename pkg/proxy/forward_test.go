@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/clodevo/raven-proxy/pkg/acl"
+	"github.com/clodevo/raven-proxy/pkg/utils"
+	"github.com/valyala/fasthttp"
+)
+
+func TestIsLoop(t *testing.T) {
+	const viaName = "raven-proxy"
+	id := &utils.Identity{TenantName: "acme", ClientAddr: "192.0.2.1:50000"}
+
+	tests := []struct {
+		name   string
+		via    bool
+		header map[string]string // headers of the request as the client sent it
+		before bool              // whether the client's request is a loop
+		after  bool              // whether the forwarded request is a loop when it comes back
+	}{
+		{name: "with Via", via: true, after: true},
+		{name: "without Via", via: false, after: true},
+		{
+			name:   "through another proxy without Via",
+			via:    false,
+			header: map[string]string{loopHeader: "other-token", fasthttp.HeaderVia: "1.1 other-proxy"},
+			after:  true,
+		},
+		{
+			name:   "Via naming this proxy",
+			via:    false,
+			header: map[string]string{fasthttp.HeaderVia: "1.0 other-proxy, 1.1 " + viaName},
+			before: true,
+			after:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req fasthttp.Request
+			req.SetRequestURI("http://example.com/")
+			for name, value := range tt.header {
+				req.Header.Set(name, value)
+			}
+			if got := isLoop(&req, viaName); got != tt.before {
+				t.Errorf("isLoop before forwarding = %v, want %v", got, tt.before)
+			}
+
+			prepareRequest(&req, id, &acl.ForwardingPolicy{Via: tt.via}, viaName)
+			if got := isLoop(&req, viaName); got != tt.after {
+				t.Errorf("isLoop after forwarding = %v, want %v", got, tt.after)
+			}
+			if via := string(req.Header.Peek(fasthttp.HeaderVia)); !tt.via && via != tt.header[fasthttp.HeaderVia] {
+				t.Errorf("Via = %q without Via forwarding", via)
+			}
+		})
+	}
+}
+
+func TestPrepareResponseStripsLoopHeader(t *testing.T) {
+	var resp fasthttp.Response
+	resp.Header.Set(loopHeader, loopToken)
+	prepareResponse(&resp, &acl.ForwardingPolicy{}, "raven-proxy")
+	if value := resp.Header.Peek(loopHeader); len(value) > 0 {
+		t.Errorf("response still carries %s: %s", loopHeader, value)
+	}
+}
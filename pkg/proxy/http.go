@@ -1,48 +1,124 @@
 package proxy
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/clodevo/raven-proxy/pkg/acl"
 	"github.com/clodevo/raven-proxy/pkg/config"
+	"github.com/clodevo/raven-proxy/pkg/ratelimit"
+	"github.com/clodevo/raven-proxy/pkg/usage"
 	"github.com/clodevo/raven-proxy/pkg/utils"
 	"github.com/valyala/fasthttp"
 )
 
-var (
-	defaultDialer fasthttp.TCPDialer
-	fastclient    fasthttp.Client
-)
+// blockedByPolicy is the reason reported to clients whose destination is denied by their ACL.
+const blockedByPolicy = "Forbidden: The request is blocked by policy."
+
+// connectEstablished is the response to a CONNECT request that opens a tunnel.
+const connectEstablished = "HTTP/1.1 200 Connection established\r\n\r\n"
+
+// addressBlocked is the reason reported to clients whose destination resolves to an address blocked for their tenant.
+const addressBlocked = "Forbidden: The destination address is blocked by policy."
+
+func handleFastHTTP(ctx *fasthttp.RequestCtx, cfg *config.ProxyConfig, id *utils.Identity, parent *url.URL, aclManager *acl.ACLManager, traffic connTraffic) {
+	if isLoop(&ctx.Request, cfg.ViaName) {
+		utils.GetLogger().Debug("[%s] Request to %s already went through this proxy", id, ctx.Host())
+		ctx.Response.SetStatusCode(fasthttp.StatusLoopDetected)
+		return
+	}
+	if parent != nil {
+		utils.GetLogger().Debug("[%s] Forwarding via upstream %s", id, parent.Redacted())
+	}
 
-func handleFastHTTP(ctx *fasthttp.RequestCtx, cfg *config.ProxyConfig) {
-	if err := fastclient.DoTimeout(&ctx.Request, &ctx.Response, cfg.Timeout); err != nil {
-		fmt.Printf("Client timeout: %s\n", err)
+	// Connection is hop-by-hop, but the client's wish to close still applies to our side.
+	closeClient := ctx.Request.Header.ConnectionClose()
+	fwd := aclManager.ForwardingFor(id.TenantName)
+	prepareRequest(&ctx.Request, id, fwd, cfg.ViaName)
+	traffic.sendRequest(&ctx.Request)
+	if err := clientFor(parent, id.TenantName, aclManager, cfg.Timeout).DoTimeout(&ctx.Request, &ctx.Response, cfg.Timeout); err != nil {
+		if errors.Is(err, acl.ErrAddressBlocked) {
+			utils.GetLogger().Debug("[%s] %v", id, err)
+			ctx.Response.Reset()
+			ctx.Response.SetStatusCode(fasthttp.StatusForbidden)
+			ctx.Response.SetBodyString(addressBlocked)
+			return
+		}
+		fmt.Printf("[%s] Client timeout: %s\n", id, err)
+	}
+	prepareResponse(&ctx.Response, fwd, cfg.ViaName)
+	traffic.sendResponse(&ctx.Response)
+	if closeClient {
+		ctx.SetConnectionClose()
 	}
 }
 
-func handleFastHTTPS(ctx *fasthttp.RequestCtx, cfg *config.ProxyConfig) {
-	if len(ctx.Host()) > 0 {
-		fmt.Printf("Connect to: %s\n", ctx.Host())
+// handleFastHTTPS tunnels a CONNECT request. admitted is called once the
+// tunnel is set up, and release once it closes.
+func handleFastHTTPS(ctx *fasthttp.RequestCtx, cfg *config.ProxyConfig, id *utils.Identity, parent *url.URL, aclManager *acl.ACLManager, admitted, release func()) {
+	// The hijack handler must not touch ctx, so capture the target up front.
+	host := string(ctx.Host())
+	if len(host) > 0 {
+		fmt.Printf("[%s] Connect to: %s\n", id, host)
 	}
+	if parent != nil {
+		utils.GetLogger().Debug("[%s] Tunnelling via upstream %s", id, parent.Redacted())
+	}
+	intercept := aclManager.InterceptFor(id.TenantName, host)
+	inspect := aclManager.TunnelPolicyFor(id.TenantName)
+	// The response is written by the hijack handler rather than by fasthttp,
+	// which skips the handler when it cannot write the response and would
+	// then never release the tunnel's slot.
+	ctx.HijackSetNoResponse(true)
 	ctx.Hijack(func(clientConn net.Conn) {
-		destConn, err := defaultDialer.DialTimeout(string(ctx.Host()), 10*time.Second)
-		if err != nil {
-			fmt.Printf("Dial timeout: %s\n", err)
+		defer release()
+		defer admitted()
+		if _, err := clientConn.Write([]byte(connectEstablished)); err != nil {
+			return
+		}
+		// Only peek at tunnels that are intercepted or inspected, since
+		// protocols where the server speaks first stall until the peek times out.
+		if intercept == nil && inspect == nil {
+			tunnel(clientConn, host, parent, id, aclManager, admitted)
 			return
 		}
 
-		defer clientConn.Close()
-		defer destConn.Close()
-
-		go transfer(destConn, clientConn)
-		transfer(clientConn, destConn)
+		conn, isTLS, ok := inspectTunnel(clientConn, host, id, aclManager, inspect)
+		if !ok {
+			return
+		}
+		if intercept != nil && isTLS {
+			// Requests of the intercepted tunnel are admitted one by one.
+			admitted()
+			interceptTunnel(conn, host, cfg, id, aclManager, intercept)
+			return
+		}
+		tunnel(conn, host, parent, id, aclManager, admitted)
 	})
 }
 
+// tunnel connects clientConn to host and copies bytes in both directions until either side closes.
+// established is called once host is connected.
+func tunnel(clientConn net.Conn, host string, parent *url.URL, id *utils.Identity, aclManager *acl.ACLManager, established func()) {
+	destConn, err := dial(parent, host, 10*time.Second, aclManager.AddressesFor(id.TenantName, acl.ProtocolConnect))
+	if err != nil {
+		fmt.Printf("[%s] Dial timeout: %s\n", id, err)
+		return
+	}
+	established()
+
+	defer clientConn.Close()
+	defer destConn.Close()
+
+	trafficFor(aclManager, id).relay(clientConn, clientConn, destConn)
+}
+
 func transfer(destination io.WriteCloser, source io.ReadCloser) {
 	defer func() {
 		if err := recover(); err != nil {
@@ -57,28 +133,76 @@ func transfer(destination io.WriteCloser, source io.ReadCloser) {
 
 func FastHTTPHandler(cfg *config.ProxyConfig, aclManager *acl.ACLManager) fasthttp.RequestHandler {
 	return func(ctx *fasthttp.RequestCtx) {
-		if !utils.Authenticate(ctx) {
+		id, ok := utils.Authenticate(ctx)
+		if !ok {
 			return
 		}
 
-		fmt.Printf("Tenant name for ACL check: %s\n", utils.TenantNameRequest)
+		utils.GetLogger().Debug("[%s] Tenant name for ACL check: %s", id, id.TenantName)
+		serveRequest(ctx, cfg, id, aclManager)
+	}
+}
 
-		// if _, exists := aclManager.TenantLists[utils.TenantNameRequest]; !exists {
-		aclManager.TenantLists[utils.TenantNameRequest] = aclManager.LoadTenantLists(utils.TenantNameRequest)
-		// }
+// serveRequest evaluates an authenticated request against the tenant's
+// ACL and forwards it. It is shared by the proxy listeners and by
+// intercepted tunnels, so decrypted HTTPS requests get the same treatment
+// as plain HTTP.
+func serveRequest(ctx *fasthttp.RequestCtx, cfg *config.ProxyConfig, id *utils.Identity, aclManager *acl.ACLManager) {
+	isConnect := strings.EqualFold(string(ctx.Method()), fasthttp.MethodConnect)
 
-		if !aclManager.IsRequestAllowed(ctx, utils.TenantNameRequest) {
-			utils.GetLogger().Debug("Request blocked by ACL policy")
-			ctx.Response.SetStatusCode(fasthttp.StatusForbidden)
-			ctx.Response.SetBodyString("Forbidden: The request is blocked by policy.")
-			return
-		}
+	kind := ratelimit.KindRequests
+	if isConnect {
+		kind = ratelimit.KindTunnels
+	}
+	if allowed, wait := allowRate(aclManager, id, kind); !allowed {
+		ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, strconv.Itoa(ratelimit.RetryAfterSeconds(wait)))
+		ctx.Response.SetStatusCode(fasthttp.StatusTooManyRequests)
+		ctx.Response.SetBodyString(rateLimited)
+		return
+	}
+	if blocked, resetIn := quotaBlocks(aclManager, id); blocked {
+		ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, strconv.Itoa(ratelimit.RetryAfterSeconds(resetIn)))
+		ctx.Response.SetStatusCode(fasthttp.StatusTooManyRequests)
+		ctx.Response.SetBodyString(quotaExhausted)
+		return
+	}
 
-		switch strings.ToUpper(string(ctx.Method())) {
-		case fasthttp.MethodConnect:
-			handleFastHTTPS(ctx, cfg)
-		default:
-			handleFastHTTP(ctx, cfg)
+	if !aclManager.IsRequestAllowed(ctx, id) {
+		utils.GetLogger().Debug("[%s] Request blocked by ACL policy", id)
+		ctx.Response.SetStatusCode(fasthttp.StatusForbidden)
+		ctx.Response.SetBodyString(blockedByPolicy)
+		return
+	}
+
+	admitted, ok := admit(aclManager, id)
+	if !ok {
+		ctx.Response.Header.Set(fasthttp.HeaderRetryAfter, "1")
+		ctx.Response.SetStatusCode(fasthttp.StatusServiceUnavailable)
+		ctx.Response.SetBodyString(proxyBusy)
+		return
+	}
+
+	release, ok := acquireSlot(aclManager, id, kind)
+	if !ok {
+		admitted()
+		ctx.Response.SetStatusCode(fasthttp.StatusTooManyRequests)
+		if isConnect {
+			ctx.Response.SetBodyString(tooManyTunnels)
+		} else {
+			ctx.Response.SetBodyString(tooManyInFlight)
 		}
+		return
+	}
+
+	parent := aclManager.UpstreamFor(id.TenantName, string(ctx.Host()))
+	usage.GetMeter().Account(id).AddRequest()
+
+	if isConnect {
+		// The tunnel is admitted until it is set up, and holds its slot until it closes.
+		handleFastHTTPS(ctx, cfg, id, parent, aclManager, admitted, release)
+	} else {
+		defer admitted()
+		defer release()
+		handleFastHTTP(ctx, cfg, id, parent, aclManager, trafficFor(aclManager, id))
 	}
 }
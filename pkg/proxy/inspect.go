@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/clodevo/raven-proxy/pkg/acl"
+	"github.com/clodevo/raven-proxy/pkg/utils"
+)
+
+// inspectTunnel peeks at the first bytes the client sends into a CONNECT
+// tunnel to host. It reports whether they start a TLS handshake and, when
+// the tenant's tunnel policy asks for it, checks the ClientHello SNI against
+// the tenant ACL and flags non-TLS traffic. It returns the connection to
+// use from now on, with the peeked bytes put back, and false when the
+// tunnel must be closed.
+func inspectTunnel(clientConn net.Conn, host string, id *utils.Identity, aclManager *acl.ACLManager, inspect *acl.TunnelPolicy) (net.Conn, bool, bool) {
+	logger := utils.GetLogger()
+
+	br := bufio.NewReaderSize(clientConn, maxSniffBytes)
+	clientConn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	isTLS := isTLSHandshake(br)
+	var sni string
+	if isTLS && inspect != nil && inspect.SNI != acl.TunnelIgnore {
+		sni, _ = peekClientHelloSNI(br)
+	}
+	clientConn.SetReadDeadline(time.Time{})
+	conn := replayConn(clientConn, br)
+	if inspect == nil {
+		return conn, isTLS, true
+	}
+
+	if !isTLS {
+		return conn, isTLS, applyTunnelMode(inspect.NonTLS, "[%s] Tunnel to %s does not carry TLS", id, host)
+	}
+
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		hostname = host
+	}
+	if sni == "" || strings.EqualFold(sni, hostname) {
+		return conn, isTLS, true
+	}
+	if aclManager.IsHostAllowed(id, net.JoinHostPort(sni, port), acl.ProtocolConnect) {
+		logger.Debug("[%s] Tunnel to %s carries TLS for %s, which is also allowed", id, host, sni)
+		return conn, isTLS, true
+	}
+	return conn, isTLS, applyTunnelMode(inspect.SNI, "[%s] Tunnel to %s carries TLS for blocked host %s", id, host, sni)
+}
+
+// applyTunnelMode logs a failed tunnel inspection as the mode requires and
+// reports whether the tunnel may carry on.
+func applyTunnelMode(mode acl.TunnelMode, format string, args ...interface{}) bool {
+	switch mode {
+	case acl.TunnelReject:
+		utils.GetLogger().Info(format+", closing it", args...)
+		return false
+	case acl.TunnelLog:
+		utils.GetLogger().Info(format, args...)
+	}
+	return true
+}
@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clodevo/raven-proxy/pkg/acl"
+	"github.com/clodevo/raven-proxy/pkg/config"
+	"github.com/clodevo/raven-proxy/pkg/utils"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// mintedCertLifetime is the validity of certificates minted for intercepted hosts.
+	mintedCertLifetime = 24 * time.Hour
+	// mintedCertRenewal is how long before expiry a cached certificate is replaced.
+	mintedCertRenewal = time.Hour
+	// maxMintedCerts bounds the certificate cache.
+	maxMintedCerts = 4096
+)
+
+// mintedCerts caches the certificates minted for intercepted hosts, keyed
+// by CA and host name, so that each host costs one signature per CA.
+var mintedCerts = &certCache{entries: make(map[string]*mintedCert)}
+
+type certCache struct {
+	mu      sync.Mutex
+	entries map[string]*mintedCert
+}
+
+type mintedCert struct {
+	cert    *tls.Certificate
+	renewAt time.Time
+}
+
+// get returns a certificate for hostname signed by the CA of intercept,
+// minting one if none is cached.
+func (c *certCache) get(intercept *acl.InterceptPolicy, hostname string) (*tls.Certificate, error) {
+	key := intercept.CAID + "/" + hostname
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, found := c.entries[key]
+	c.mu.Unlock()
+	if found && now.Before(entry.renewAt) {
+		return entry.cert, nil
+	}
+
+	cert, notAfter, err := mintCertificate(intercept, hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxMintedCerts {
+		for k, e := range c.entries {
+			if now.After(e.renewAt) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxMintedCerts {
+			c.entries = make(map[string]*mintedCert)
+		}
+	}
+	c.entries[key] = &mintedCert{cert: cert, renewAt: notAfter.Add(-mintedCertRenewal)}
+	return cert, nil
+}
+
+// mintCertificate issues a short-lived server certificate for hostname.
+func mintCertificate(intercept *acl.InterceptPolicy, hostname string) (*tls.Certificate, time.Time, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	ca := intercept.CA.Leaf
+	now := time.Now()
+	notAfter := now.Add(mintedCertLifetime)
+	if ca.NotAfter.Before(notAfter) {
+		notAfter = ca.NotAfter
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hostname},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	if ip := net.ParseIP(hostname); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{hostname}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, key.Public(), intercept.CA.PrivateKey)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der, intercept.CA.Certificate[0]},
+		PrivateKey:  key,
+	}, notAfter, nil
+}
+
+// interceptTunnel terminates the client's TLS inside a CONNECT tunnel with a
+// certificate minted for host, and serves the decrypted requests through
+// serveRequest. conn must start with a TLS handshake.
+func interceptTunnel(conn net.Conn, host string, cfg *config.ProxyConfig, id *utils.Identity, aclManager *acl.ACLManager, intercept *acl.InterceptPolicy) {
+	logger := utils.GetLogger()
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		hostname = host
+	}
+	defer conn.Close()
+
+	tlsConn := tls.Server(conn, &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			// Always present the CONNECT target, whatever name the client asks for.
+			return mintedCerts.get(intercept, hostname)
+		},
+	})
+	tlsConn.SetDeadline(time.Now().Add(cfg.Timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		logger.Debug("[%s] TLS interception handshake for %s failed: %v", id, host, err)
+		return
+	}
+	tlsConn.SetDeadline(time.Time{})
+	logger.Debug("[%s] Intercepting tunnel to %s", id, host)
+
+	server := &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) {
+			requestHost, _, err := net.SplitHostPort(string(ctx.Host()))
+			if err != nil {
+				requestHost = string(ctx.Host())
+			}
+			if !strings.EqualFold(requestHost, hostname) {
+				logger.Debug("[%s] Intercepted request for %s inside tunnel to %s", id, ctx.Host(), host)
+				ctx.Response.SetStatusCode(fasthttp.StatusMisdirectedRequest)
+				return
+			}
+			if bytes.Equal(ctx.Method(), []byte(fasthttp.MethodConnect)) {
+				ctx.Response.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+				return
+			}
+
+			ctx.Request.URI().SetScheme("https")
+			ctx.Request.SetHost(host)
+			logger.Debug("[%s] Intercepted %s %s", id, ctx.Method(), ctx.Request.URI())
+			serveRequest(ctx, cfg, id, aclManager)
+		},
+		ReadTimeout:           cfg.Timeout,
+		WriteTimeout:          cfg.Timeout,
+		IdleTimeout:           3 * cfg.Timeout,
+		NoDefaultServerHeader: true,
+		NoDefaultDate:         true,
+		NoDefaultContentType:  true,
+	}
+	if err := server.ServeConn(tlsConn); err != nil {
+		logger.Debug("[%s] Intercepted tunnel to %s closed: %v", id, host, err)
+	}
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/clodevo/raven-proxy/pkg/acl"
+	"github.com/clodevo/raven-proxy/pkg/ratelimit"
+	"github.com/clodevo/raven-proxy/pkg/usage"
+	"github.com/clodevo/raven-proxy/pkg/utils"
+)
+
+// quotaExhausted is the reason reported to clients of tenants that have used up a blocking quota.
+const quotaExhausted = "Too Many Requests: The traffic quota has been exhausted."
+
+// quotaCheckInterval is how often open tunnels and UDP associations
+// re-check their tenant's quota, which is otherwise only checked when they
+// open.
+const quotaCheckInterval = 5 * time.Second
+
+// quotaState returns the tenant's quota, whether it is exhausted and when
+// the period ends. Usage that cannot be read does not count as exhausted.
+func quotaState(aclManager *acl.ACLManager, id *utils.Identity) (*acl.QuotaPolicy, bool, time.Duration) {
+	quota := aclManager.QuotaFor(id.TenantName)
+	if quota == nil {
+		return nil, false, 0
+	}
+	totals, err := usage.GetMeter().Usage(id.TenantID, quota.Period)
+	if err != nil {
+		utils.GetLogger().Debug("[%s] Not enforcing quota: %v", id, err)
+		return quota, false, 0
+	}
+	_, end := usage.PeriodBounds(quota.Period, time.Now())
+	return quota, quota.Exhausted(totals), time.Until(end)
+}
+
+// quotaBlocks reports whether the tenant's quota is exhausted and blocks
+// traffic, and how long until it resets.
+func quotaBlocks(aclManager *acl.ACLManager, id *utils.Identity) (bool, time.Duration) {
+	quota, exhausted, resetIn := quotaState(aclManager, id)
+	if !exhausted || quota.Action != acl.QuotaBlock {
+		return false, 0
+	}
+	utils.GetLogger().Debug("[%s] Quota of tenant %s exhausted, blocking until %s", id, id.TenantName, time.Now().Add(resetIn).UTC().Format(time.RFC3339))
+	return true, resetIn
+}
+
+// quotaGate holds the quota throttle once a throttling quota is exhausted
+// while traffic is under way.
+type quotaGate struct {
+	throttle atomic.Pointer[ratelimit.Throttle]
+}
+
+// wait pays for n bytes on the quota throttle, if the quota was exhausted.
+func (g *quotaGate) wait(n int) {
+	if throttle := g.throttle.Load(); throttle != nil {
+		throttle.WaitN(n)
+	}
+}
+
+// reader returns a reader that pays for every byte read from r on the
+// quota throttle, once the quota is exhausted.
+func (g *quotaGate) reader(r io.Reader) io.Reader {
+	return &quotaReader{r: r, gate: g}
+}
+
+type quotaReader struct {
+	r    io.Reader
+	gate *quotaGate
+}
+
+func (r *quotaReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.gate.wait(n)
+	return n, err
+}
+
+// watchQuota re-checks the tenant's quota every quotaCheckInterval until
+// done is closed. Once a blocking quota is exhausted it calls block and
+// stops; once a throttling one is, the returned gate starts paying into
+// the quota throttle, unless the traffic already does.
+func (t connTraffic) watchQuota(done <-chan struct{}, block func()) *quotaGate {
+	gate := &quotaGate{}
+	go func() {
+		ticker := time.NewTicker(quotaCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			quota, exhausted, _ := quotaState(t.aclManager, t.id)
+			if !exhausted {
+				continue
+			}
+			switch quota.Action {
+			case acl.QuotaBlock:
+				utils.GetLogger().Debug("[%s] Quota of tenant %s exhausted, closing open tunnel", t.id, t.id.TenantName)
+				block()
+				return
+			case acl.QuotaThrottle:
+				if !t.quotaThrottled && gate.throttle.Load() == nil {
+					utils.GetLogger().Debug("[%s] Quota of tenant %s exhausted, throttling open tunnel", t.id, t.id.TenantName)
+					gate.throttle.Store(ratelimit.GetLimiter().Throttle(ratelimit.ThrottleID{Tenant: t.id.TenantName, Direction: ratelimit.DirectionQuota}, quota.Throttle))
+				}
+			}
+		}
+	}()
+	return gate
+}
@@ -0,0 +1,55 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/clodevo/raven-proxy/pkg/acl"
+	"github.com/clodevo/raven-proxy/pkg/ratelimit"
+	"github.com/clodevo/raven-proxy/pkg/utils"
+)
+
+// rateLimited is the reason reported to clients that exceed their tenant's or key's rate limit.
+const rateLimited = "Too Many Requests: The rate limit has been exceeded."
+
+// proxyBusy is the reason reported to clients whose request found no capacity within the maximum queue time.
+const proxyBusy = "Service Unavailable: The proxy is busy."
+
+// Reasons reported to clients that hit their tenant's or key's concurrency caps.
+const (
+	tooManyTunnels  = "Too Many Requests: Too many tunnels are open."
+	tooManyInFlight = "Too Many Requests: Too many requests are in flight."
+)
+
+// allowRate charges one operation of the given kind to the identity's
+// bucket. When the bucket is empty it returns false and how long the
+// client should wait.
+func allowRate(aclManager *acl.ACLManager, id *utils.Identity, kind string) (bool, time.Duration) {
+	bucket, limit := aclManager.RateLimitFor(id, kind)
+	allowed, wait := ratelimit.GetLimiter().Allow(bucket, limit)
+	if !allowed {
+		utils.GetLogger().Debug("[%s] Rate limit for %s exceeded, next token in %s", id, kind, wait)
+	}
+	return allowed, wait
+}
+
+// acquireSlot opens one operation of the given kind against the identity's
+// concurrency caps. The caller must call release when the operation ends.
+func acquireSlot(aclManager *acl.ACLManager, id *utils.Identity, kind string) (release func(), ok bool) {
+	release, ok = ratelimit.GetLimiter().Acquire(aclManager.ConcurrencyFor(id, kind)...)
+	if !ok {
+		utils.GetLogger().Debug("[%s] Concurrency cap for %s reached", id, kind)
+	}
+	return release, ok
+}
+
+// admit waits for the fair scheduler to admit an operation of the
+// identity's tenant. The caller must call release when the operation ends,
+// or for tunnels once they are set up; later calls of release do nothing.
+func admit(aclManager *acl.ACLManager, id *utils.Identity) (release func(), ok bool) {
+	scheduling := aclManager.SchedulingFor(id.TenantName)
+	release, ok = ratelimit.GetScheduler().Admit(id.TenantName, scheduling.Weight, scheduling.Priority)
+	if !ok {
+		utils.GetLogger().Debug("[%s] No capacity for tenant %s within the maximum queue time", id, id.TenantName)
+	}
+	return release, ok
+}
@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	// maxSniffBytes bounds how much of a connection is buffered to find the
+	// destination name. Readers passed to the peek functions must be created
+	// with bufio.NewReaderSize(conn, maxSniffBytes).
+	maxSniffBytes = 16*1024 + 5
+
+	tlsRecordTypeHandshake    = 0x16
+	tlsHandshakeClientHello   = 0x01
+	tlsExtensionServerName    = 0x0000
+	tlsServerNameTypeHostName = 0x00
+)
+
+var (
+	errNotTLS      = errors.New("not a TLS handshake")
+	errNoSNI       = errors.New("ClientHello carries no server name")
+	errMalformedCH = errors.New("malformed ClientHello")
+	errNoHostFound = errors.New("no Host header found")
+)
+
+// isTLSHandshake reports whether the buffered stream starts with a TLS handshake record.
+func isTLSHandshake(br *bufio.Reader) bool {
+	header, err := br.Peek(3)
+	return err == nil && header[0] == tlsRecordTypeHandshake && header[1] == 0x03
+}
+
+// peekClientHelloSNI returns the server name of the TLS ClientHello at the
+// start of br without consuming any bytes.
+func peekClientHelloSNI(br *bufio.Reader) (string, error) {
+	header, err := br.Peek(5)
+	if err != nil {
+		return "", err
+	}
+	if header[0] != tlsRecordTypeHandshake || header[1] != 0x03 {
+		return "", errNotTLS
+	}
+	length := int(binary.BigEndian.Uint16(header[3:5]))
+	if 5+length > maxSniffBytes {
+		return "", errMalformedCH
+	}
+	record, err := br.Peek(5 + length)
+	if err != nil {
+		return "", err
+	}
+	return parseClientHelloSNI(record[5:])
+}
+
+// parseClientHelloSNI extracts the server_name extension from a ClientHello handshake message.
+func parseClientHelloSNI(data []byte) (string, error) {
+	// Handshake: type(1) length(3) version(2) random(32)
+	if len(data) < 38 || data[0] != tlsHandshakeClientHello {
+		return "", errMalformedCH
+	}
+	data = data[38:]
+
+	// session_id, cipher_suites and compression_methods are skipped.
+	skip := func(lengthBytes int) bool {
+		if len(data) < lengthBytes {
+			return false
+		}
+		n := 0
+		for _, b := range data[:lengthBytes] {
+			n = n<<8 | int(b)
+		}
+		if len(data) < lengthBytes+n {
+			return false
+		}
+		data = data[lengthBytes+n:]
+		return true
+	}
+	if !skip(1) || !skip(2) || !skip(1) {
+		return "", errMalformedCH
+	}
+	if len(data) < 2 {
+		return "", errNoSNI
+	}
+	extensionsLength := int(binary.BigEndian.Uint16(data))
+	data = data[2:]
+	if len(data) < extensionsLength {
+		return "", errMalformedCH
+	}
+	data = data[:extensionsLength]
+
+	for len(data) >= 4 {
+		extType := binary.BigEndian.Uint16(data)
+		extLength := int(binary.BigEndian.Uint16(data[2:]))
+		data = data[4:]
+		if len(data) < extLength {
+			return "", errMalformedCH
+		}
+		if extType != tlsExtensionServerName {
+			data = data[extLength:]
+			continue
+		}
+
+		// server_name_list: length(2) then entries of type(1) length(2) name
+		ext := data[:extLength]
+		if len(ext) < 2 {
+			return "", errMalformedCH
+		}
+		ext = ext[2:]
+		for len(ext) >= 3 {
+			nameType := ext[0]
+			nameLength := int(binary.BigEndian.Uint16(ext[1:]))
+			ext = ext[3:]
+			if len(ext) < nameLength {
+				return "", errMalformedCH
+			}
+			if nameType == tlsServerNameTypeHostName {
+				return strings.ToLower(string(ext[:nameLength])), nil
+			}
+			ext = ext[nameLength:]
+		}
+		return "", errNoSNI
+	}
+	return "", errNoSNI
+}
+
+// peekHTTPHost returns the Host header of the HTTP request at the start of
+// br without consuming any bytes.
+func peekHTTPHost(br *bufio.Reader) (string, error) {
+	// Request lines start with an upper-case method token.
+	if first, err := br.Peek(1); err != nil || first[0] < 'A' || first[0] > 'Z' {
+		return "", errNoHostFound
+	}
+	for size := 1; ; {
+		data, err := br.Peek(size)
+		if end := bytes.Index(data, []byte("\r\n\r\n")); end >= 0 {
+			req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data[:end+4])))
+			if err != nil {
+				return "", err
+			}
+			if req.Host == "" {
+				return "", errNoHostFound
+			}
+			return req.Host, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if size >= maxSniffBytes {
+			return "", errNoHostFound
+		}
+		// Ask for one byte more than is buffered so Peek reads whatever has arrived.
+		size = br.Buffered() + 1
+		if size > maxSniffBytes {
+			size = maxSniffBytes
+		}
+	}
+}
+
+// sniffDestination returns the host name the client is trying to reach,
+// taken from the TLS SNI or the HTTP Host header. It returns an empty
+// string when neither is present.
+func sniffDestination(br *bufio.Reader) string {
+	if isTLSHandshake(br) {
+		if name, err := peekClientHelloSNI(br); err == nil {
+			return name
+		}
+		return ""
+	}
+	if host, err := peekHTTPHost(br); err == nil {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			return h
+		}
+		return host
+	}
+	return ""
+}
+
+// replayConn returns conn with the bytes buffered in br while sniffing put
+// back in front of it. Unlike reading through br, it does not surface a
+// read deadline that expired during sniffing.
+func replayConn(conn net.Conn, br *bufio.Reader) net.Conn {
+	buffered, _ := br.Peek(br.Buffered())
+	prefix := append([]byte(nil), buffered...)
+	return &bufferedConn{Conn: conn, r: io.MultiReader(bytes.NewReader(prefix), conn)}
+}
@@ -0,0 +1,474 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/clodevo/raven-proxy/pkg/acl"
+	"github.com/clodevo/raven-proxy/pkg/config"
+	"github.com/clodevo/raven-proxy/pkg/ratelimit"
+	"github.com/clodevo/raven-proxy/pkg/utils"
+)
+
+// SOCKS5 protocol constants (RFC 1928 and RFC 1929).
+const (
+	socks5Version        = 0x05
+	socks5AuthVersion    = 0x01
+	socks5MethodUserPass = 0x02
+	socks5MethodNone     = 0xff
+
+	socks5CmdConnect      = 0x01
+	socks5CmdUDPAssociate = 0x03
+
+	socks5AtypIPv4   = 0x01
+	socks5AtypDomain = 0x03
+	socks5AtypIPv6   = 0x04
+
+	socks5Succeeded           = 0x00
+	socks5GeneralFailure      = 0x01
+	socks5NotAllowed          = 0x02
+	socks5HostUnreachable     = 0x04
+	socks5CommandNotSupported = 0x07
+	socks5AddrNotSupported    = 0x08
+)
+
+// errAuthMethodRequired is returned for clients that do not offer
+// username/password authentication.
+var errAuthMethodRequired = errors.New("client does not support username/password authentication")
+
+// ListenAndServeSOCKS5 serves SOCKS5 clients on cfg.SOCKS5Addr. Clients
+// authenticate with username/password, where the username is the tenant
+// name and the password is the API key, and their destinations are checked
+// against the same ACLs as the HTTP proxy.
+func ListenAndServeSOCKS5(cfg *config.ProxyConfig, aclManager *acl.ACLManager) error {
+	ln, err := net.Listen("tcp", cfg.SOCKS5Addr)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go serveSOCKS5(conn, cfg, aclManager)
+	}
+}
+
+func serveSOCKS5(conn net.Conn, cfg *config.ProxyConfig, aclManager *acl.ACLManager) {
+	defer conn.Close()
+	logger := utils.GetLogger()
+
+	conn.SetDeadline(time.Now().Add(cfg.Timeout))
+	reader := bufio.NewReader(conn)
+
+	id, err := socks5Handshake(reader, conn)
+	if err != nil {
+		logger.Debug("SOCKS5 handshake from %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	cmd, dest, err := readSOCKS5Request(reader)
+	if err != nil {
+		logger.Debug("[%s] SOCKS5 request failed: %v", id, err)
+		code := byte(socks5GeneralFailure)
+		if errors.Is(err, errSOCKS5AddrType) {
+			code = socks5AddrNotSupported
+		}
+		writeSOCKS5Reply(conn, code, nil)
+		return
+	}
+	if cmd != socks5CmdConnect && cmd != socks5CmdUDPAssociate {
+		writeSOCKS5Reply(conn, socks5CommandNotSupported, nil)
+		return
+	}
+	if blocked, _ := quotaBlocks(aclManager, id); blocked {
+		writeSOCKS5Reply(conn, socks5GeneralFailure, nil)
+		return
+	}
+	if allowed, _ := allowRate(aclManager, id, ratelimit.KindTunnels); !allowed {
+		writeSOCKS5Reply(conn, socks5GeneralFailure, nil)
+		return
+	}
+	if cmd == socks5CmdConnect && !aclManager.IsHostAllowed(id, dest, acl.ProtocolConnect) {
+		logger.Debug("[%s] %s (SOCKS5 CONNECT %s)", id, blockedByPolicy, dest)
+		writeSOCKS5Reply(conn, socks5NotAllowed, nil)
+		return
+	}
+
+	// Both commands are admitted until they are set up, and hold a tunnel
+	// slot for as long as the client stays connected.
+	admitted, ok := admit(aclManager, id)
+	if !ok {
+		writeSOCKS5Reply(conn, socks5GeneralFailure, nil)
+		return
+	}
+	defer admitted()
+	release, ok := acquireSlot(aclManager, id, ratelimit.KindTunnels)
+	if !ok {
+		writeSOCKS5Reply(conn, socks5GeneralFailure, nil)
+		return
+	}
+	defer release()
+
+	conn.SetDeadline(time.Time{})
+	if cmd == socks5CmdConnect {
+		socks5Connect(conn, reader, dest, id, aclManager, admitted)
+	} else {
+		socks5UDPAssociate(conn, reader, dest, id, cfg, aclManager, admitted)
+	}
+}
+
+// socks5Handshake negotiates username/password authentication and verifies
+// the credentials as tenant_name and api_key.
+func socks5Handshake(reader *bufio.Reader, conn net.Conn) (*utils.Identity, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	if header[0] != socks5Version {
+		return nil, fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, methods); err != nil {
+		return nil, err
+	}
+
+	supported := false
+	for _, method := range methods {
+		if method == socks5MethodUserPass {
+			supported = true
+		}
+	}
+	if !supported {
+		conn.Write([]byte{socks5Version, socks5MethodNone})
+		return nil, errAuthMethodRequired
+	}
+	if _, err := conn.Write([]byte{socks5Version, socks5MethodUserPass}); err != nil {
+		return nil, err
+	}
+
+	// RFC 1929: VER ULEN UNAME PLEN PASSWD
+	version, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if version != socks5AuthVersion {
+		return nil, fmt.Errorf("unsupported auth version %d", version)
+	}
+	username, err := readSOCKS5String(reader)
+	if err != nil {
+		return nil, err
+	}
+	password, err := readSOCKS5String(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := utils.VerifyCredentials(username, password, conn.RemoteAddr().String())
+	if err != nil {
+		conn.Write([]byte{socks5AuthVersion, 0x01})
+		return nil, err
+	}
+	if _, err := conn.Write([]byte{socks5AuthVersion, 0x00}); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+func readSOCKS5String(reader *bufio.Reader) (string, error) {
+	length, err := reader.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+var errSOCKS5AddrType = errors.New("address type not supported")
+
+// readSOCKS5Request reads VER CMD RSV ATYP DST.ADDR DST.PORT and returns the
+// command and the destination as host:port.
+func readSOCKS5Request(reader *bufio.Reader) (byte, string, error) {
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return 0, "", err
+	}
+	if header[0] != socks5Version {
+		return 0, "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	dest, err := readSOCKS5Addr(reader)
+	if err != nil {
+		return 0, "", err
+	}
+	return header[1], dest, nil
+}
+
+// readSOCKS5Addr reads ATYP DST.ADDR DST.PORT.
+func readSOCKS5Addr(reader io.Reader) (string, error) {
+	atyp := make([]byte, 1)
+	if _, err := io.ReadFull(reader, atyp); err != nil {
+		return "", err
+	}
+
+	var host string
+	switch atyp[0] {
+	case socks5AtypIPv4, socks5AtypIPv6:
+		size := net.IPv4len
+		if atyp[0] == socks5AtypIPv6 {
+			size = net.IPv6len
+		}
+		ip := make(net.IP, size)
+		if _, err := io.ReadFull(reader, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socks5AtypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(reader, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(reader, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", errSOCKS5AddrType
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(reader, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// appendSOCKS5Addr encodes addr as ATYP BND.ADDR BND.PORT.
+func appendSOCKS5Addr(buf []byte, addr net.Addr) []byte {
+	var ip net.IP
+	var port int
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip, port = a.IP, a.Port
+	case *net.UDPAddr:
+		ip, port = a.IP, a.Port
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		buf = append(buf, socks5AtypIPv4)
+		buf = append(buf, ip4...)
+	} else if ip != nil {
+		buf = append(buf, socks5AtypIPv6)
+		buf = append(buf, ip.To16()...)
+	} else {
+		buf = append(buf, socks5AtypIPv4, 0, 0, 0, 0)
+	}
+	return binary.BigEndian.AppendUint16(buf, uint16(port))
+}
+
+func writeSOCKS5Reply(conn net.Conn, code byte, bound net.Addr) error {
+	reply := appendSOCKS5Addr([]byte{socks5Version, code, 0x00}, bound)
+	_, err := conn.Write(reply)
+	return err
+}
+
+// socks5Connect connects the client to dest and calls established once
+// the destination is connected.
+func socks5Connect(conn net.Conn, reader *bufio.Reader, dest string, id *utils.Identity, aclManager *acl.ACLManager, established func()) {
+	parent := aclManager.UpstreamFor(id.TenantName, dest)
+	utils.GetLogger().Debug("[%s] SOCKS5 connect to: %s", id, dest)
+
+	destConn, err := dial(parent, dest, 10*time.Second, aclManager.AddressesFor(id.TenantName, acl.ProtocolConnect))
+	if err != nil {
+		utils.GetLogger().Debug("[%s] Dial timeout: %s", id, err)
+		code := byte(socks5HostUnreachable)
+		if errors.Is(err, acl.ErrAddressBlocked) {
+			code = socks5NotAllowed
+		}
+		writeSOCKS5Reply(conn, code, nil)
+		return
+	}
+	defer destConn.Close()
+	established()
+
+	if err := writeSOCKS5Reply(conn, socks5Succeeded, destConn.LocalAddr()); err != nil {
+		return
+	}
+
+	// The reader may already hold bytes the client sent after its request.
+	traffic := trafficFor(aclManager, id)
+	traffic.account.AddRequest()
+	traffic.relay(conn, reader, destConn)
+}
+
+// socks5UDPAssociate relays UDP datagrams for the client for as long as the
+// control connection stays open, and calls established once the relay is
+// listening. Only datagrams from the client's address, and from the port
+// it declared in dest unless it left it as 0, are relayed. Every
+// destination is checked against the tenant ACL and charged as a tunnel to
+// the rate limit the first time it is sent to; datagrams to blocked
+// destinations, or beyond the rate, are dropped. Datagrams pay into the
+// tenant's bandwidth throttles like tunnel traffic, and the association
+// ends once a blocking quota is exhausted.
+func socks5UDPAssociate(conn net.Conn, reader *bufio.Reader, dest string, id *utils.Identity, cfg *config.ProxyConfig, aclManager *acl.ACLManager, established func()) {
+	logger := utils.GetLogger()
+
+	localIP := conn.LocalAddr().(*net.TCPAddr).IP
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP})
+	if err != nil {
+		logger.Debug("[%s] SOCKS5 UDP relay failed: %v", id, err)
+		writeSOCKS5Reply(conn, socks5GeneralFailure, nil)
+		return
+	}
+	defer relay.Close()
+	established()
+
+	if err := writeSOCKS5Reply(conn, socks5Succeeded, relay.LocalAddr()); err != nil {
+		return
+	}
+	logger.Debug("[%s] SOCKS5 UDP associate on %s", id, relay.LocalAddr())
+	traffic := trafficFor(aclManager, id)
+	traffic.account.AddRequest()
+
+	// The association ends when the client closes the control connection.
+	go func() {
+		io.Copy(io.Discard, reader)
+		relay.Close()
+	}()
+	done := make(chan struct{})
+	defer close(done)
+	quota := traffic.watchQuota(done, func() {
+		relay.Close()
+		conn.Close()
+	})
+
+	// Without a declared port, the client's port is the one it first sends from.
+	clientIP := conn.RemoteAddr().(*net.TCPAddr).IP
+	var clientAddr *net.UDPAddr
+	if _, port, err := net.SplitHostPort(dest); err == nil && port != "0" {
+		clientPort, _ := strconv.Atoi(port)
+		clientAddr = &net.UDPAddr{IP: clientIP, Port: clientPort}
+	}
+	allowed := make(map[string]bool)
+	// Only destinations the client has sent to may send datagrams back.
+	remotes := make(map[string]bool)
+
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := relay.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		fromClient := from.IP.Equal(clientIP) && (clientAddr == nil || from.Port == clientAddr.Port)
+		if fromClient && clientAddr == nil {
+			clientAddr = from
+		}
+
+		if !fromClient {
+			// A reply from a destination: prepend its address and hand it to the client.
+			if clientAddr == nil || !remotes[from.String()] {
+				continue
+			}
+			ratelimit.Wait(n, traffic.download...)
+			quota.wait(n)
+			packet := appendSOCKS5Addr([]byte{0, 0, 0}, from)
+			packet = append(packet, buf[:n]...)
+			relay.WriteToUDP(packet, clientAddr)
+			traffic.account.AddOut(n)
+			continue
+		}
+
+		// RSV(2) FRAG(1) ATYP DST.ADDR DST.PORT DATA; fragmentation is not supported.
+		if n < 4 || buf[2] != 0 {
+			continue
+		}
+		payloadReader := &countingReader{data: buf[3:n]}
+		dest, err := readSOCKS5Addr(payloadReader)
+		if err != nil {
+			continue
+		}
+		payload := buf[3+payloadReader.offset : n]
+
+		ok, seen := allowed[dest]
+		if !seen {
+			if rateOK, _ := allowRate(aclManager, id, ratelimit.KindTunnels); !rateOK {
+				// Not remembered, so that a later datagram may try again.
+				continue
+			}
+			ok = aclManager.IsHostAllowed(id, dest, acl.ProtocolConnect) && aclManager.UpstreamFor(id.TenantName, dest) == nil
+			allowed[dest] = ok
+			if !ok {
+				logger.Debug("[%s] %s (SOCKS5 UDP %s)", id, blockedByPolicy, dest)
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		destAddr, err := resolveUDPAddr(dest, cfg.Timeout, aclManager.AddressesFor(id.TenantName, acl.ProtocolConnect))
+		if err != nil {
+			logger.Debug("[%s] SOCKS5 UDP resolve %s failed: %v", id, dest, err)
+			continue
+		}
+		remotes[destAddr.String()] = true
+		ratelimit.Wait(len(payload), traffic.upload...)
+		quota.wait(len(payload))
+		relay.WriteToUDP(payload, destAddr)
+		traffic.account.AddIn(len(payload))
+	}
+}
+
+// resolveUDPAddr resolves dest to the first of its addresses that the
+// address policy allows.
+func resolveUDPAddr(dest string, timeout time.Duration, addresses *acl.AddressPolicy) (*net.UDPAddr, error) {
+	host, port, err := net.SplitHostPort(dest)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := lookupIP(host, timeout)
+	if err != nil {
+		return nil, err
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if err = addresses.Check(ip, port); err == nil {
+			return &net.UDPAddr{IP: ip, Port: portNum}, nil
+		}
+	}
+	return nil, err
+}
+
+// countingReader reads from a byte slice and remembers how much was consumed.
+type countingReader struct {
+	data   []byte
+	offset int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	if r.offset >= len(r.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[r.offset:])
+	r.offset += n
+	return n, nil
+}
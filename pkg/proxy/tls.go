@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/clodevo/raven-proxy/pkg/config"
+	"github.com/clodevo/raven-proxy/pkg/utils"
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// tlsReloadDebounce groups the events of a certificate rotation, which
+	// usually rewrites the certificate and the key one after the other.
+	tlsReloadDebounce = 500 * time.Millisecond
+	// tlsWatchRetryInterval is how often the watcher is set up again after
+	// the certificate directory became unavailable.
+	tlsWatchRetryInterval = 10 * time.Second
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsReloader serves the TLS configuration of the proxy listener and
+// rebuilds it when the certificate, key or client CA files change, so that
+// rotated certificates are picked up without a restart.
+type tlsReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	minVersion   uint16
+
+	current atomic.Pointer[tls.Config]
+	logger  *utils.Logger
+}
+
+// NewTLSListener listens on cfg.TLSAddr and wraps accepted connections in
+// TLS using cfg.TLSCertFile and cfg.TLSKeyFile. When cfg.TLSClientCAFile is
+// set, clients must present a certificate signed by one of its CAs.
+func NewTLSListener(cfg *config.ProxyConfig) (net.Listener, error) {
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return nil, errors.New("TLS listener requires both a certificate and a key file")
+	}
+	minVersion, ok := tlsVersions[cfg.TLSMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("unsupported minimum TLS version %q", cfg.TLSMinVersion)
+	}
+
+	r := &tlsReloader{
+		certFile:     cfg.TLSCertFile,
+		keyFile:      cfg.TLSKeyFile,
+		clientCAFile: cfg.TLSClientCAFile,
+		minVersion:   minVersion,
+		logger:       utils.GetLogger(),
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	r.watch()
+
+	ln, err := net.Listen("tcp", cfg.TLSAddr)
+	if err != nil {
+		return nil, err
+	}
+	return tls.NewListener(ln, &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current.Load(), nil
+		},
+	}), nil
+}
+
+// reload builds a new configuration from the files on disk. The previous
+// configuration stays in use if any file cannot be loaded.
+func (r *tlsReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS certificate: %w", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   r.minVersion,
+	}
+	if r.clientCAFile != "" {
+		pem, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("loading TLS client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in TLS client CA file %s", r.clientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	r.current.Store(tlsConfig)
+	return nil
+}
+
+// watch starts a background goroutine that reloads the configuration
+// whenever one of its files changes.
+func (r *tlsReloader) watch() {
+	go func() {
+		for {
+			if err := r.watchFiles(); err != nil {
+				r.logger.Info("TLS certificate watcher stopped: %v", err)
+			}
+			time.Sleep(tlsWatchRetryInterval)
+		}
+	}()
+}
+
+// watchFiles runs a single fsnotify session. The directories are watched
+// rather than the files, since rotations commonly replace the files by
+// renaming (e.g. Kubernetes secret volumes).
+func (r *tlsReloader) watchFiles() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	files := map[string]bool{}
+	for _, file := range []string{r.certFile, r.keyFile, r.clientCAFile} {
+		if file == "" {
+			continue
+		}
+		files[filepath.Clean(file)] = true
+		if err := watcher.Add(filepath.Dir(file)); err != nil {
+			return err
+		}
+	}
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			// Kubernetes swaps a "..data" symlink rather than touching the files.
+			if files[filepath.Clean(event.Name)] || filepath.Base(event.Name) == "..data" {
+				debounce = time.After(tlsReloadDebounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			r.logger.Debug("TLS certificate watcher error: %v", err)
+		case <-debounce:
+			debounce = nil
+			if err := r.reload(); err != nil {
+				r.logger.Info("Error reloading TLS certificate, keeping the current one: %v", err)
+				continue
+			}
+			r.logger.Info("Reloaded TLS certificate from %s", r.certFile)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net"
+
+	"github.com/clodevo/raven-proxy/pkg/acl"
+	"github.com/clodevo/raven-proxy/pkg/ratelimit"
+	"github.com/clodevo/raven-proxy/pkg/usage"
+	"github.com/clodevo/raven-proxy/pkg/utils"
+	"github.com/valyala/fasthttp"
+)
+
+// connTraffic meters and shapes the traffic of one client connection. It
+// holds the account the bytes are counted on and the bandwidth throttles
+// they pay into: the tenant's shared throttle for each direction, a
+// per-connection cap when the tenant sets one, and the quota throttle once
+// a throttling quota is exhausted.
+type connTraffic struct {
+	account  *usage.Account
+	upload   []*ratelimit.Throttle
+	download []*ratelimit.Throttle
+
+	// The identity the traffic is for, to re-check its quota while a
+	// tunnel stays open, and whether it already pays into the quota
+	// throttle.
+	aclManager     *acl.ACLManager
+	id             *utils.Identity
+	quotaThrottled bool
+}
+
+func trafficFor(aclManager *acl.ACLManager, id *utils.Identity) connTraffic {
+	bandwidth := aclManager.BandwidthFor(id.TenantName)
+	limiter := ratelimit.GetLimiter()
+
+	traffic := connTraffic{
+		account:  usage.GetMeter().Account(id),
+		upload:   []*ratelimit.Throttle{limiter.Throttle(ratelimit.ThrottleID{Tenant: id.TenantName, Direction: ratelimit.DirectionUpload}, bandwidth.Upload)},
+		download: []*ratelimit.Throttle{limiter.Throttle(ratelimit.ThrottleID{Tenant: id.TenantName, Direction: ratelimit.DirectionDownload}, bandwidth.Download)},
+
+		aclManager: aclManager,
+		id:         id,
+	}
+	if !bandwidth.PerConnection.Unlimited() {
+		traffic.upload = append(traffic.upload, ratelimit.NewThrottle(bandwidth.PerConnection))
+		traffic.download = append(traffic.download, ratelimit.NewThrottle(bandwidth.PerConnection))
+	}
+	if quota, exhausted, _ := quotaState(aclManager, id); exhausted && quota.Action == acl.QuotaThrottle {
+		// Both directions share the quota throttle, since the quota counts both.
+		throttle := limiter.Throttle(ratelimit.ThrottleID{Tenant: id.TenantName, Direction: ratelimit.DirectionQuota}, quota.Throttle)
+		traffic.upload = append(traffic.upload, throttle)
+		traffic.download = append(traffic.download, throttle)
+		traffic.quotaThrottled = true
+	}
+	return traffic
+}
+
+// relay copies bytes between the client and the destination until either
+// side closes. Reads from the client go through clientReader, which may
+// hold bytes already consumed from clientConn. The tenant's quota is
+// re-checked while the tunnel is open: once exhausted, a blocking quota
+// closes it and a throttling one slows it down.
+func (t connTraffic) relay(clientConn net.Conn, clientReader io.Reader, destConn net.Conn) {
+	done := make(chan struct{})
+	defer close(done)
+	quota := t.watchQuota(done, func() {
+		clientConn.Close()
+		destConn.Close()
+	})
+
+	upload := t.account.CountIn(quota.reader(ratelimit.NewReader(clientReader, t.upload...)))
+	download := t.account.CountOut(quota.reader(ratelimit.NewReader(destConn, t.download...)))
+	go func() {
+		transfer(destConn, &bufferedConn{Conn: clientConn, r: upload})
+		// Pass the client's end of stream on, so that the destination
+		// closes its side and the tunnel does not linger.
+		if cw, ok := destConn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			destConn.Close()
+		}
+	}()
+	transfer(clientConn, &bufferedConn{Conn: destConn, r: download})
+}
+
+// sendRequest counts the request and pays for its body before it is sent upstream.
+func (t connTraffic) sendRequest(req *fasthttp.Request) {
+	t.account.AddIn(len(req.Header.Header()) + len(req.Body()))
+	ratelimit.Wait(len(req.Body()), t.upload...)
+}
+
+// sendResponse counts the response and makes its body stream to the
+// client at the permitted rate. Unlimited responses are only metered.
+func (t connTraffic) sendResponse(resp *fasthttp.Response) {
+	body := resp.Body()
+	t.account.AddOut(len(resp.Header.Header()) + len(body))
+	if len(body) == 0 {
+		return
+	}
+	if !ratelimit.Limited(t.download...) {
+		ratelimit.Wait(len(body), t.download...)
+		return
+	}
+	body = append([]byte(nil), body...)
+	resp.SetBodyStream(ratelimit.NewReader(bytes.NewReader(body), t.download...), len(body))
+}
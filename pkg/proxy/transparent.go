@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clodevo/raven-proxy/pkg/acl"
+	"github.com/clodevo/raven-proxy/pkg/config"
+	"github.com/clodevo/raven-proxy/pkg/ratelimit"
+	"github.com/clodevo/raven-proxy/pkg/utils"
+)
+
+// sniffTimeout bounds how long the transparent listener waits for the
+// client to send a ClientHello or request line. Protocols where the server
+// speaks first fall back to the original destination address after it.
+const sniffTimeout = 2 * time.Second
+
+// sourceTenant maps a client network to the tenant its traffic belongs to.
+type sourceTenant struct {
+	network *net.IPNet
+	tenant  string
+}
+
+// parseSourceTenants parses "CIDR=tenant" entries.
+func parseSourceTenants(entries []string) ([]sourceTenant, error) {
+	tenants := make([]sourceTenant, 0, len(entries))
+	for _, entry := range entries {
+		cidr, tenant, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || tenant == "" {
+			return nil, fmt.Errorf("invalid transparent tenant mapping %q, expected CIDR=tenant", entry)
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transparent tenant mapping %q: %w", entry, err)
+		}
+		tenants = append(tenants, sourceTenant{network: network, tenant: tenant})
+	}
+	return tenants, nil
+}
+
+// tenantForSource returns the tenant of the most specific network containing ip.
+func tenantForSource(tenants []sourceTenant, ip net.IP) string {
+	tenant, bestPrefix := "", -1
+	for _, candidate := range tenants {
+		if !candidate.network.Contains(ip) {
+			continue
+		}
+		if prefix, _ := candidate.network.Mask.Size(); prefix > bestPrefix {
+			tenant, bestPrefix = candidate.tenant, prefix
+		}
+	}
+	return tenant
+}
+
+// ListenAndServeTransparent serves connections redirected to
+// cfg.TransparentAddr by iptables (REDIRECT or TPROXY). The destination is
+// recovered from the socket and refined with the TLS SNI or HTTP Host
+// header; the tenant is chosen from the client's source address.
+func ListenAndServeTransparent(cfg *config.ProxyConfig, aclManager *acl.ACLManager) error {
+	switch cfg.TransparentMode {
+	case config.TransparentModeRedirect, config.TransparentModeTProxy:
+	default:
+		return fmt.Errorf("unsupported transparent mode %q, expected %q or %q", cfg.TransparentMode, config.TransparentModeRedirect, config.TransparentModeTProxy)
+	}
+	tenants, err := parseSourceTenants(cfg.TransparentTenants)
+	if err != nil {
+		return err
+	}
+
+	ln, err := listenTransparent(cfg.TransparentAddr, cfg.TransparentMode)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go serveTransparent(conn, cfg, aclManager, tenants, ln.Addr().(*net.TCPAddr))
+	}
+}
+
+// targetsProxy reports whether the original destination of a connection
+// is the transparent listener itself, which would make the proxy connect
+// to itself. With REDIRECT the local address of the connection is the
+// listener's; with TPROXY it is the original destination, so the address
+// the listener is bound to is compared instead.
+func targetsProxy(original *net.TCPAddr, conn net.Conn, mode string, bound *net.TCPAddr) bool {
+	listener := conn.LocalAddr().(*net.TCPAddr)
+	if mode == config.TransparentModeTProxy {
+		listener = bound
+	}
+	if original.Port != listener.Port {
+		return false
+	}
+	if original.IP.Equal(listener.IP) || original.IP.IsLoopback() {
+		return true
+	}
+	return listener.IP.IsUnspecified() && isLocalIP(original.IP)
+}
+
+// isLocalIP reports whether ip is assigned to one of the host's interfaces.
+func isLocalIP(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if network, ok := addr.(*net.IPNet); ok && network.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveTransparent serves a connection accepted by the transparent
+// listener, which is bound to bound.
+func serveTransparent(conn net.Conn, cfg *config.ProxyConfig, aclManager *acl.ACLManager, tenants []sourceTenant, bound *net.TCPAddr) {
+	defer conn.Close()
+	logger := utils.GetLogger()
+
+	original, err := originalDst(conn, cfg.TransparentMode)
+	if err != nil {
+		logger.Debug("Transparent connection from %s: cannot recover original destination: %v", conn.RemoteAddr(), err)
+		return
+	}
+	if targetsProxy(original, conn, cfg.TransparentMode, bound) {
+		logger.Debug("Transparent connection from %s targets the proxy itself, refusing", conn.RemoteAddr())
+		return
+	}
+
+	clientIP := conn.RemoteAddr().(*net.TCPAddr).IP
+	tenantName := tenantForSource(tenants, clientIP)
+	if tenantName == "" {
+		logger.Debug("Transparent connection from %s: no tenant mapped for this source", conn.RemoteAddr())
+		return
+	}
+	id, err := utils.IdentifyTenant(tenantName, conn.RemoteAddr().String())
+	if err != nil {
+		logger.Debug("Transparent connection from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	if allowed, _ := allowRate(aclManager, id, ratelimit.KindTunnels); !allowed {
+		return
+	}
+	if blocked, _ := quotaBlocks(aclManager, id); blocked {
+		return
+	}
+	release, ok := acquireSlot(aclManager, id, ratelimit.KindTunnels)
+	if !ok {
+		return
+	}
+	defer release()
+
+	br := bufio.NewReaderSize(conn, maxSniffBytes)
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	host := sniffDestination(br)
+	conn.SetReadDeadline(time.Time{})
+	if host == "" {
+		host = original.IP.String()
+	}
+	dest := net.JoinHostPort(host, strconv.Itoa(original.Port))
+
+	if !aclManager.IsHostAllowed(id, dest, acl.ProtocolConnect) {
+		logger.Debug("[%s] %s (transparent %s, original destination %s)", id, blockedByPolicy, dest, original)
+		return
+	}
+	// Admission covers setting the connection up; the slot bounds it while it is open.
+	admitted, ok := admit(aclManager, id)
+	if !ok {
+		return
+	}
+	defer admitted()
+
+	parent := aclManager.UpstreamFor(id.TenantName, dest)
+	logger.Debug("[%s] Transparent connect to: %s (original destination %s)", id, dest, original)
+
+	// The sniffed name is dialed rather than the original address, so the
+	// client reaches exactly the destination the ACL allowed.
+	destConn, err := dial(parent, dest, 10*time.Second, aclManager.AddressesFor(id.TenantName, acl.ProtocolConnect))
+	if err != nil {
+		logger.Debug("[%s] Dial timeout: %s", id, err)
+		return
+	}
+	defer destConn.Close()
+	admitted()
+
+	client := replayConn(conn, br)
+	traffic := trafficFor(aclManager, id)
+	traffic.account.AddRequest()
+	traffic.relay(client, client, destConn)
+}
@@ -0,0 +1,83 @@
+//go:build linux
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"github.com/clodevo/raven-proxy/pkg/config"
+	"golang.org/x/sys/unix"
+)
+
+// soOriginalDst is SO_ORIGINAL_DST (and IP6T_SO_ORIGINAL_DST) from linux/netfilter_ipv4.h.
+const soOriginalDst = 80
+
+// listenTransparent opens the transparent listener. In tproxy mode the
+// socket is marked IP_TRANSPARENT so it can accept connections addressed to
+// foreign destinations.
+func listenTransparent(addr, mode string) (net.Listener, error) {
+	var lc net.ListenConfig
+	if mode == config.TransparentModeTProxy {
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+				if sockErr == nil && network == "tcp6" {
+					sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		}
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// originalDst returns the destination the client originally connected to.
+// With TPROXY it is the local address of the socket; with REDIRECT it is
+// read from conntrack through SO_ORIGINAL_DST.
+func originalDst(conn net.Conn, mode string) (*net.TCPAddr, error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil, errors.New("not a TCP connection")
+	}
+	local := tcpConn.LocalAddr().(*net.TCPAddr)
+	if mode == config.TransparentModeTProxy {
+		return local, nil
+	}
+
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var addr *net.TCPAddr
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if local.IP.To4() != nil {
+			// struct sockaddr_in: family(2) port(2) addr(4)
+			var mreq *unix.IPv6Mreq
+			if mreq, sockErr = unix.GetsockoptIPv6Mreq(int(fd), unix.SOL_IP, soOriginalDst); sockErr == nil {
+				m := mreq.Multiaddr
+				addr = &net.TCPAddr{IP: net.IPv4(m[4], m[5], m[6], m[7]), Port: int(m[2])<<8 | int(m[3])}
+			}
+			return
+		}
+		// struct sockaddr_in6 is returned in the address part of IPv6MTUInfo.
+		var info *unix.IPv6MTUInfo
+		if info, sockErr = unix.GetsockoptIPv6MTUInfo(int(fd), unix.SOL_IPV6, soOriginalDst); sockErr == nil {
+			port := (*[2]byte)(unsafe.Pointer(&info.Addr.Port))
+			addr = &net.TCPAddr{IP: net.IP(info.Addr.Addr[:]), Port: int(port[0])<<8 | int(port[1])}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return addr, sockErr
+}
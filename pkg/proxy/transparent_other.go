@@ -0,0 +1,18 @@
+//go:build !linux
+
+package proxy
+
+import (
+	"errors"
+	"net"
+)
+
+var errTransparentUnsupported = errors.New("transparent proxy mode is only supported on Linux")
+
+func listenTransparent(addr, mode string) (net.Listener, error) {
+	return nil, errTransparentUnsupported
+}
+
+func originalDst(conn net.Conn, mode string) (*net.TCPAddr, error) {
+	return nil, errTransparentUnsupported
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/clodevo/raven-proxy/pkg/acl"
+	"github.com/valyala/fasthttp"
+	xproxy "golang.org/x/net/proxy"
+)
+
+// upstreamClients caches one fasthttp.Client per parent proxy so that
+// connections through the same parent are pooled.
+var upstreamClients sync.Map // parent URL string -> *fasthttp.Client
+
+// directClients caches one fasthttp.Client per tenant for direct requests.
+// Pools are not shared between tenants, since a connection to an address
+// one tenant may reach must not be reused for another.
+var directClients sync.Map // tenant name -> *fasthttp.Client
+
+// dial connects to addr, either directly or through the given parent proxy.
+// Direct connections are checked against addresses; through a parent the
+// parent resolves the name, so only the parent can check it.
+func dial(parent *url.URL, addr string, timeout time.Duration, addresses *acl.AddressPolicy) (net.Conn, error) {
+	if parent == nil {
+		return dialDirect(addr, timeout, addresses)
+	}
+	switch parent.Scheme {
+	case "socks5", "socks5h":
+		return dialSOCKS5Parent(parent, addr, timeout)
+	default:
+		return dialHTTPParent(parent, addr, timeout)
+	}
+}
+
+// clientFor returns the HTTP client to use for the tenant's plain HTTP
+// requests routed through parent, or sent directly when parent is nil.
+func clientFor(parent *url.URL, tenantName string, aclManager *acl.ACLManager, timeout time.Duration) *fasthttp.Client {
+	if parent == nil {
+		if client, ok := directClients.Load(tenantName); ok {
+			return client.(*fasthttp.Client)
+		}
+		client, _ := directClients.LoadOrStore(tenantName, &fasthttp.Client{
+			Dial: func(addr string) (net.Conn, error) {
+				// Looked up on every dial, so that ACL reloads apply to new connections.
+				return dialDirect(addr, timeout, aclManager.AddressesFor(tenantName, acl.ProtocolHTTP))
+			},
+		})
+		return client.(*fasthttp.Client)
+	}
+
+	key := parent.String()
+	if client, ok := upstreamClients.Load(key); ok {
+		return client.(*fasthttp.Client)
+	}
+	client, _ := upstreamClients.LoadOrStore(key, &fasthttp.Client{
+		Dial: func(addr string) (net.Conn, error) {
+			return dial(parent, addr, timeout, nil)
+		},
+	})
+	return client.(*fasthttp.Client)
+}
+
+// dialHTTPParent opens a tunnel to addr through an HTTP parent proxy using CONNECT.
+func dialHTTPParent(parent *url.URL, addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := dialDirect(parent.Host, timeout, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+	if parent.User != nil {
+		password, _ := parent.User.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(parent.User.Username() + ":" + password))
+		req += "Proxy-Authorization: Basic " + auth + "\r\n"
+	}
+	req += "\r\n"
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write([]byte(req)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("parent proxy %s refused CONNECT to %s: %s", parent.Host, addr, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// dialSOCKS5Parent opens a connection to addr through a SOCKS5 parent proxy.
+func dialSOCKS5Parent(parent *url.URL, addr string, timeout time.Duration) (net.Conn, error) {
+	var auth *xproxy.Auth
+	if parent.User != nil {
+		password, _ := parent.User.Password()
+		auth = &xproxy.Auth{User: parent.User.Username(), Password: password}
+	}
+
+	dialer, err := xproxy.SOCKS5("tcp", parent.Host, auth, timeoutDialer(timeout))
+	if err != nil {
+		return nil, err
+	}
+	return dialer.Dial("tcp", addr)
+}
+
+// timeoutDialer adapts dialDirect to the x/net/proxy.Dialer interface.
+type timeoutDialer time.Duration
+
+func (d timeoutDialer) Dial(network, addr string) (net.Conn, error) {
+	return dialDirect(addr, time.Duration(d), nil)
+}
+
+// bufferedConn is a net.Conn whose first bytes were already consumed from
+// the socket; reads go through r, which replays them first.
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
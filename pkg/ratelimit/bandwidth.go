@@ -0,0 +1,240 @@
+package ratelimit
+
+import (
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/clodevo/raven-proxy/pkg/models"
+)
+
+// Directions of throttled traffic, seen from the client. DirectionQuota
+// is the throttle shared by both directions once a quota is exhausted.
+const (
+	DirectionUpload   = "upload"
+	DirectionDownload = "download"
+	DirectionQuota    = "quota"
+)
+
+const (
+	// rateWindow is the time constant of the moving average behind live rate readings.
+	rateWindow = 5 * time.Second
+	// defaultChunk bounds a single read through unlimited throttles.
+	defaultChunk = 32 * 1024
+)
+
+// ThrottleID identifies the shared bandwidth throttle of a tenant in one direction.
+type ThrottleID struct {
+	Tenant    string
+	Direction string
+}
+
+// Throttle is a token bucket over bytes. Unlike the request buckets it does
+// not refuse traffic; callers wait until the bytes they moved are paid for.
+// It also keeps a moving average of the throughput for live readings.
+type Throttle struct {
+	mu     sync.Mutex
+	limit  Limit
+	tokens float64
+	last   time.Time
+
+	total    uint64
+	weighted float64 // exponentially weighted byte count, in bytes * rateWindow
+	weighAt  time.Time
+}
+
+// NewThrottle returns a throttle that starts with a full bucket.
+func NewThrottle(limit Limit) *Throttle {
+	now := time.Now()
+	t := &Throttle{last: now, weighAt: now}
+	t.setLimit(limit)
+	t.tokens = float64(t.limit.Burst)
+	return t
+}
+
+func (t *Throttle) setLimit(limit Limit) {
+	if !limit.Unlimited() && limit.Burst < 1 {
+		// Allow at least a second's worth, and never less than one chunk.
+		limit.Burst = int(math.Max(math.Ceil(limit.Rate), defaultChunk))
+	}
+	t.limit = limit
+}
+
+// WaitN records n bytes and blocks until the bucket has paid for them.
+func (t *Throttle) WaitN(n int) {
+	if n <= 0 {
+		return
+	}
+	now := time.Now()
+
+	t.mu.Lock()
+	t.total += uint64(n)
+	t.weighted = t.weighted*math.Exp(-now.Sub(t.weighAt).Seconds()/rateWindow.Seconds()) + float64(n)
+	t.weighAt = now
+
+	var wait time.Duration
+	if !t.limit.Unlimited() {
+		t.tokens = math.Min(float64(t.limit.Burst), t.tokens+now.Sub(t.last).Seconds()*t.limit.Rate)
+		t.last = now
+		t.tokens -= float64(n)
+		if t.tokens < 0 {
+			wait = time.Duration(-t.tokens / t.limit.Rate * float64(time.Second))
+		}
+	}
+	t.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// chunk is the largest read that fits in the bucket at once.
+func (t *Throttle) chunk() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.limit.Unlimited() {
+		return defaultChunk
+	}
+	return t.limit.Burst
+}
+
+// limited reports whether the throttle caps the rate.
+func (t *Throttle) limited() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return !t.limit.Unlimited()
+}
+
+// state returns the bucket and live rate of the throttle at now.
+func (t *Throttle) state(now time.Time) (limit Limit, tokens float64, rate float64, total uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tokens = float64(t.limit.Burst)
+	if !t.limit.Unlimited() {
+		tokens = math.Min(float64(t.limit.Burst), t.tokens+now.Sub(t.last).Seconds()*t.limit.Rate)
+	}
+	weighted := t.weighted * math.Exp(-now.Sub(t.weighAt).Seconds()/rateWindow.Seconds())
+	return t.limit, tokens, weighted / rateWindow.Seconds(), t.total
+}
+
+// Limited reports whether any of the throttles caps the rate.
+func Limited(throttles ...*Throttle) bool {
+	for _, t := range throttles {
+		if t.limited() {
+			return true
+		}
+	}
+	return false
+}
+
+// Wait pays for n bytes on every throttle, in chunks no larger than the
+// smallest burst so that a large body is spread out rather than delayed
+// and then sent at once.
+func Wait(n int, throttles ...*Throttle) {
+	chunk := chunkSize(throttles)
+	for n > 0 {
+		size := n
+		if size > chunk {
+			size = chunk
+		}
+		for _, t := range throttles {
+			t.WaitN(size)
+		}
+		n -= size
+	}
+}
+
+func chunkSize(throttles []*Throttle) int {
+	chunk := defaultChunk
+	for _, t := range throttles {
+		if c := t.chunk(); c < chunk {
+			chunk = c
+		}
+	}
+	return chunk
+}
+
+// NewReader returns a reader that pays for every byte read from r on all throttles.
+func NewReader(r io.Reader, throttles ...*Throttle) io.Reader {
+	if len(throttles) == 0 {
+		return r
+	}
+	return &throttledReader{r: r, throttles: throttles}
+}
+
+type throttledReader struct {
+	r         io.Reader
+	throttles []*Throttle
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if chunk := chunkSize(r.throttles); len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := r.r.Read(p)
+	for _, t := range r.throttles {
+		t.WaitN(n)
+	}
+	return n, err
+}
+
+// Throttle returns the shared throttle of id, applying limit to it. Tenant
+// throttles live as long as the process, since tunnels hold on to them.
+func (l *Limiter) Throttle(id ThrottleID, limit Limit) *Throttle {
+	l.mu.Lock()
+	t, exists := l.throttles[id]
+	if !exists {
+		t = NewThrottle(limit)
+		l.throttles[id] = t
+	}
+	l.mu.Unlock()
+
+	if exists {
+		t.mu.Lock()
+		t.setLimit(limit)
+		t.mu.Unlock()
+	}
+	return t
+}
+
+// BandwidthSnapshot returns the state of the tenant throttles, optionally
+// restricted to one tenant, ordered by tenant and direction.
+func (l *Limiter) BandwidthSnapshot(tenant string) []models.BandwidthThrottle {
+	now := time.Now()
+
+	l.mu.Lock()
+	ids := make([]ThrottleID, 0, len(l.throttles))
+	throttles := make([]*Throttle, 0, len(l.throttles))
+	for id, t := range l.throttles {
+		if tenant != "" && id.Tenant != tenant {
+			continue
+		}
+		ids = append(ids, id)
+		throttles = append(throttles, t)
+	}
+	l.mu.Unlock()
+
+	states := make([]models.BandwidthThrottle, len(ids))
+	for i, t := range throttles {
+		limit, tokens, rate, total := t.state(now)
+		states[i] = models.BandwidthThrottle{
+			Tenant:         ids[i].Tenant,
+			Direction:      ids[i].Direction,
+			BytesPerSecond: limit.Rate,
+			BurstBytes:     limit.Burst,
+			TokensBytes:    tokens,
+			CurrentRate:    rate,
+			TotalBytes:     total,
+		}
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].Tenant != states[j].Tenant {
+			return states[i].Tenant < states[j].Tenant
+		}
+		return states[i].Direction < states[j].Direction
+	})
+	return states
+}
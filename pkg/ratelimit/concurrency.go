@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/clodevo/raven-proxy/pkg/models"
+)
+
+// Slot is a cap on simultaneous operations: at most Max may be open at
+// once in the gauge of ID. A zero Max means unlimited, but the operations
+// are still counted for occupancy readings.
+type Slot struct {
+	ID  BucketID
+	Max int
+}
+
+type gauge struct {
+	max      int
+	open     int
+	rejected uint64
+	last     time.Time
+}
+
+// Acquire opens one operation in every slot's gauge, or in none of them
+// when any is full. The caller must call release once the operation ends;
+// calling it again is harmless.
+func (l *Limiter) Acquire(slots ...Slot) (release func(), ok bool) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	gauges := make([]*gauge, len(slots))
+	for i, slot := range slots {
+		g, exists := l.gauges[slot.ID]
+		if !exists {
+			g = &gauge{}
+			l.gauges[slot.ID] = g
+		}
+		g.max, g.last = slot.Max, now
+		gauges[i] = g
+	}
+	for _, g := range gauges {
+		if g.max > 0 && g.open >= g.max {
+			g.rejected++
+			return func() {}, false
+		}
+	}
+	for _, g := range gauges {
+		g.open++
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			for _, g := range gauges {
+				g.open--
+				g.last = time.Now()
+			}
+		})
+	}, true
+}
+
+// Occupancy returns the state of the concurrency gauges, optionally
+// restricted to one tenant, ordered by tenant, key and kind.
+func (l *Limiter) Occupancy(tenant string) []models.ConcurrencySlot {
+	l.mu.Lock()
+	states := make([]models.ConcurrencySlot, 0, len(l.gauges))
+	for id, g := range l.gauges {
+		if tenant != "" && id.Tenant != tenant {
+			continue
+		}
+		states = append(states, models.ConcurrencySlot{
+			Tenant:   id.Tenant,
+			APIKeyID: id.APIKeyID,
+			Kind:     id.Kind,
+			Max:      g.max,
+			Open:     g.open,
+			Rejected: g.rejected,
+			LastUsed: g.last,
+		})
+	}
+	l.mu.Unlock()
+
+	sort.Slice(states, func(i, j int) bool {
+		a, b := states[i], states[j]
+		if a.Tenant != b.Tenant {
+			return a.Tenant < b.Tenant
+		}
+		if a.APIKeyID != b.APIKeyID {
+			return a.APIKeyID < b.APIKeyID
+		}
+		return a.Kind < b.Kind
+	})
+	return states
+}
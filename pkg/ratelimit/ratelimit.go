@@ -0,0 +1,182 @@
+package ratelimit
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/clodevo/raven-proxy/pkg/models"
+)
+
+// Kinds of rate-limited operations.
+const (
+	KindRequests = "requests"
+	KindTunnels  = "tunnels"
+)
+
+// idleBucketTTL is how long a full bucket may stay unused before it is dropped.
+const idleBucketTTL = 10 * time.Minute
+
+// Limit is a token-bucket limit: Rate tokens per second, holding at most
+// Burst tokens. A zero Rate means unlimited.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Unlimited reports whether the limit lets everything through.
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0
+}
+
+// BucketID identifies a bucket. APIKeyID is empty for buckets shared by
+// all keys of a tenant.
+type BucketID struct {
+	Tenant   string
+	APIKeyID string
+	Kind     string
+}
+
+type bucket struct {
+	limit     Limit
+	tokens    float64
+	last      time.Time
+	allowed   uint64
+	throttled uint64
+}
+
+// available returns the tokens in the bucket at now.
+func (b *bucket) available(now time.Time) float64 {
+	return math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate)
+}
+
+// Limiter holds the token buckets and concurrency gauges of every tenant
+// and API key, and the bandwidth throttles of every tenant.
+type Limiter struct {
+	mu        sync.Mutex
+	buckets   map[BucketID]*bucket
+	gauges    map[BucketID]*gauge
+	throttles map[ThrottleID]*Throttle
+	lastSweep time.Time
+}
+
+var limiter *Limiter // Singleton instance of Limiter
+
+func init() {
+	limiter = NewLimiter()
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{
+		buckets:   make(map[BucketID]*bucket),
+		gauges:    make(map[BucketID]*gauge),
+		throttles: make(map[ThrottleID]*Throttle),
+		lastSweep: time.Now(),
+	}
+}
+
+// GetLimiter returns the singleton instance of the limiter.
+func GetLimiter() *Limiter {
+	return limiter
+}
+
+// Allow takes a token from the bucket of id. When the bucket is empty it
+// returns false and how long until a token is available. The limit is
+// passed on every call so that changes to the tenant's policy apply at once.
+func (l *Limiter) Allow(id BucketID, limit Limit) (bool, time.Duration) {
+	if limit.Unlimited() {
+		return true, 0
+	}
+	if limit.Burst < 1 {
+		limit.Burst = int(math.Ceil(limit.Rate))
+	}
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	b, exists := l.buckets[id]
+	if !exists {
+		b = &bucket{limit: limit, tokens: float64(limit.Burst), last: now}
+		l.buckets[id] = b
+	}
+	b.limit = limit
+	b.tokens, b.last = b.available(now), now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		b.allowed++
+		return true, 0
+	}
+	b.throttled++
+	wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep drops buckets that have been full and unused for a while, and
+// gauges with nothing open for as long. It runs at most once per idleBucketTTL.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < idleBucketTTL {
+		return
+	}
+	l.lastSweep = now
+	for id, b := range l.buckets {
+		if now.Sub(b.last) > idleBucketTTL {
+			delete(l.buckets, id)
+		}
+	}
+	for id, g := range l.gauges {
+		if g.open == 0 && now.Sub(g.last) > idleBucketTTL {
+			delete(l.gauges, id)
+		}
+	}
+}
+
+// Snapshot returns the current state of the buckets, optionally restricted
+// to one tenant, ordered by tenant, key and kind.
+func (l *Limiter) Snapshot(tenant string) []models.RateLimitBucket {
+	now := time.Now()
+
+	l.mu.Lock()
+	states := make([]models.RateLimitBucket, 0, len(l.buckets))
+	for id, b := range l.buckets {
+		if tenant != "" && id.Tenant != tenant {
+			continue
+		}
+		states = append(states, models.RateLimitBucket{
+			Tenant:    id.Tenant,
+			APIKeyID:  id.APIKeyID,
+			Kind:      id.Kind,
+			Rate:      b.limit.Rate,
+			Burst:     b.limit.Burst,
+			Tokens:    b.available(now),
+			Allowed:   b.allowed,
+			Throttled: b.throttled,
+			LastUsed:  b.last,
+		})
+	}
+	l.mu.Unlock()
+
+	sort.Slice(states, func(i, j int) bool {
+		a, b := states[i], states[j]
+		if a.Tenant != b.Tenant {
+			return a.Tenant < b.Tenant
+		}
+		if a.APIKeyID != b.APIKeyID {
+			return a.APIKeyID < b.APIKeyID
+		}
+		return a.Kind < b.Kind
+	})
+	return states
+}
+
+// RetryAfterSeconds rounds a wait up to the whole seconds of a Retry-After header.
+func RetryAfterSeconds(wait time.Duration) int {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
@@ -0,0 +1,233 @@
+package ratelimit
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/clodevo/raven-proxy/pkg/models"
+)
+
+// Priority classes of tenants, from most to least urgent.
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+var priorityRank = map[string]int{PriorityHigh: 0, PriorityNormal: 1, PriorityLow: 2}
+
+// Scheduler admits requests into the proxy pipeline, at most its capacity
+// at a time. Requests that find it full wait in a queue per tenant. When a slot
+// frees up, tenants below their guaranteed share of the capacity go first;
+// a tenant's share is the capacity split between the tenants with requests
+// in service or waiting, in proportion to their weights. Beyond that, the
+// higher priority class goes first, and within a class the tenant with the
+// fewest requests in service per unit of weight.
+type Scheduler struct {
+	mu       sync.Mutex
+	capacity int
+	maxWait  time.Duration
+	inUse    int
+	tenants  map[string]*tenantQueue
+}
+
+type tenantQueue struct {
+	weight   float64
+	priority string
+	inUse    int
+	waiting  []*waiter
+	admitted uint64
+	timedOut uint64
+}
+
+type waiter struct {
+	ready    chan struct{}
+	admitted bool
+}
+
+var scheduler *Scheduler // Singleton instance of Scheduler
+
+func init() {
+	scheduler = NewScheduler()
+}
+
+// NewScheduler returns a scheduler that admits everything until it is configured.
+func NewScheduler() *Scheduler {
+	return &Scheduler{tenants: make(map[string]*tenantQueue)}
+}
+
+// GetScheduler returns the singleton instance of the scheduler.
+func GetScheduler() *Scheduler {
+	return scheduler
+}
+
+// Configure sets the number of requests served at once and how long a
+// request may wait for a slot. A capacity of 0 turns scheduling off.
+func (s *Scheduler) Configure(capacity int, maxWait time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.capacity = capacity
+	s.maxWait = maxWait
+	s.dispatch()
+}
+
+// Admit waits until the tenant may have a request served, for at most the
+// configured maximum wait. The caller must call release once the request
+// has been served; calling it again is harmless. It returns false when the
+// request waited too long.
+func (s *Scheduler) Admit(tenant string, weight float64, priority string) (release func(), ok bool) {
+	s.mu.Lock()
+	if s.capacity <= 0 {
+		s.mu.Unlock()
+		return func() {}, true
+	}
+
+	q, exists := s.tenants[tenant]
+	if !exists {
+		q = &tenantQueue{}
+		s.tenants[tenant] = q
+	}
+	q.weight, q.priority = weight, priority
+
+	w := &waiter{ready: make(chan struct{})}
+	q.waiting = append(q.waiting, w)
+	s.dispatch()
+	maxWait := s.maxWait
+	s.mu.Unlock()
+
+	release = s.releaser(q)
+	select {
+	case <-w.ready:
+		return release, true
+	default:
+	}
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	select {
+	case <-w.ready:
+		return release, true
+	case <-timer.C:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w.admitted {
+		// Admitted just as the wait ran out.
+		return release, true
+	}
+	for i, queued := range q.waiting {
+		if queued == w {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			break
+		}
+	}
+	q.timedOut++
+	return func() {}, false
+}
+
+func (s *Scheduler) releaser(q *tenantQueue) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.inUse--
+			q.inUse--
+			s.dispatch()
+		})
+	}
+}
+
+// dispatch admits waiting requests while there is capacity. s.mu must be held.
+func (s *Scheduler) dispatch() {
+	for s.inUse < s.capacity {
+		q := s.next()
+		if q == nil {
+			return
+		}
+		w := q.waiting[0]
+		q.waiting = q.waiting[1:]
+		w.admitted = true
+		close(w.ready)
+		s.inUse++
+		q.inUse++
+		q.admitted++
+	}
+}
+
+// next picks the tenant whose request is admitted next, or nil when nobody waits.
+func (s *Scheduler) next() *tenantQueue {
+	var totalWeight float64
+	for _, q := range s.tenants {
+		if q.inUse > 0 || len(q.waiting) > 0 {
+			totalWeight += q.weight
+		}
+	}
+
+	var best *tenantQueue
+	bestGuaranteed := false
+	for _, q := range s.tenants {
+		if len(q.waiting) == 0 {
+			continue
+		}
+		guaranteed := float64(q.inUse) < float64(s.capacity)*q.weight/totalWeight
+		if best == nil || s.before(q, guaranteed, best, bestGuaranteed) {
+			best, bestGuaranteed = q, guaranteed
+		}
+	}
+	return best
+}
+
+// before reports whether tenant a should be served before tenant b.
+func (s *Scheduler) before(a *tenantQueue, aGuaranteed bool, b *tenantQueue, bGuaranteed bool) bool {
+	if aGuaranteed != bGuaranteed {
+		return aGuaranteed
+	}
+	if !aGuaranteed && a.priority != b.priority {
+		return priorityRank[a.priority] < priorityRank[b.priority]
+	}
+	return float64(a.inUse)/a.weight < float64(b.inUse)/b.weight
+}
+
+// State returns the occupancy of the scheduler and of every tenant that
+// has used it, ordered by tenant.
+func (s *Scheduler) State() models.SchedulerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var totalWeight float64
+	for _, q := range s.tenants {
+		if q.inUse > 0 || len(q.waiting) > 0 {
+			totalWeight += q.weight
+		}
+	}
+
+	state := models.SchedulerState{
+		Capacity: s.capacity,
+		MaxWait:  s.maxWait.String(),
+		InUse:    s.inUse,
+		Tenants:  make([]models.SchedulerTenant, 0, len(s.tenants)),
+	}
+	for name, q := range s.tenants {
+		tenant := models.SchedulerTenant{
+			Tenant:   name,
+			Weight:   q.weight,
+			Priority: q.priority,
+			InUse:    q.inUse,
+			Waiting:  len(q.waiting),
+			Admitted: q.admitted,
+			TimedOut: q.timedOut,
+		}
+		if (q.inUse > 0 || len(q.waiting) > 0) && totalWeight > 0 {
+			tenant.Share = float64(s.capacity) * q.weight / totalWeight
+		}
+		state.Waiting += len(q.waiting)
+		state.Tenants = append(state.Tenants, tenant)
+	}
+	sort.Slice(state.Tenants, func(i, j int) bool {
+		return state.Tenants[i].Tenant < state.Tenants[j].Tenant
+	})
+	return state
+}
@@ -0,0 +1,186 @@
+package resolver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// query resolves name against a single server, asking for both A and AAAA
+// records. It returns the smallest TTL of the answers, or the negative
+// caching TTL from the SOA record when the name does not exist. When one
+// of the two questions fails, the answers to the other are still returned.
+func query(ctx context.Context, server, name string) ([]net.IP, time.Duration, error) {
+	qname, err := dnsmessage.NewName(name + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var ips []net.IP
+	var failure error
+	ttl, negative := time.Duration(-1), time.Duration(-1)
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		answers, answerTTL, err := exchange(ctx, server, qname, qtype)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			failure = err
+			continue
+		}
+		if len(answers) == 0 {
+			if negative < 0 || answerTTL < negative {
+				negative = answerTTL
+			}
+			continue
+		}
+		ips = append(ips, answers...)
+		if ttl < 0 || answerTTL < ttl {
+			ttl = answerTTL
+		}
+	}
+
+	if len(ips) == 0 {
+		if failure != nil {
+			return nil, 0, failure
+		}
+		if negative <= 0 {
+			negative = negativeTTL
+		}
+		return nil, negative, ErrNotFound
+	}
+	return ips, ttl, nil
+}
+
+// exchange sends one question over UDP, retrying over TCP when the answer is truncated.
+func exchange(ctx context.Context, server string, name dnsmessage.Name, qtype dnsmessage.Type) ([]net.IP, time.Duration, error) {
+	// The ID must be unpredictable, or off-path attackers can forge answers.
+	var idBytes [2]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return nil, 0, err
+	}
+	id := binary.BigEndian.Uint16(idBytes[:])
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  name,
+			Type:  qtype,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	packet, err := msg.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	response, err := roundTrip(ctx, "udp", server, packet)
+	if err != nil {
+		return nil, 0, err
+	}
+	if response.Header.Truncated {
+		if response, err = roundTrip(ctx, "tcp", server, packet); err != nil {
+			return nil, 0, err
+		}
+	}
+	if response.Header.ID != id {
+		return nil, 0, fmt.Errorf("mismatched DNS response id from %s", server)
+	}
+
+	switch response.Header.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, soaTTL(response), ErrNotFound
+	default:
+		return nil, 0, fmt.Errorf("DNS server %s answered %s", server, response.Header.RCode)
+	}
+
+	var ips []net.IP
+	ttl := time.Duration(-1)
+	for _, answer := range response.Answers {
+		var ip net.IP
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			ip = net.IP(body.A[:])
+		case *dnsmessage.AAAAResource:
+			ip = net.IP(body.AAAA[:])
+		default:
+			// CNAME chains are followed by the recursive server; only addresses matter here.
+			continue
+		}
+		ips = append(ips, ip)
+		answerTTL := time.Duration(answer.Header.TTL) * time.Second
+		if ttl < 0 || answerTTL < ttl {
+			ttl = answerTTL
+		}
+	}
+	if len(ips) == 0 {
+		// NODATA: the name exists but has no record of this type.
+		return nil, soaTTL(response), nil
+	}
+	return ips, ttl, nil
+}
+
+// soaTTL returns the negative caching TTL advertised in the authority section.
+func soaTTL(response *dnsmessage.Message) time.Duration {
+	for _, authority := range response.Authorities {
+		if soa, ok := authority.Body.(*dnsmessage.SOAResource); ok {
+			ttl := soa.MinTTL
+			if authority.Header.TTL < ttl {
+				ttl = authority.Header.TTL
+			}
+			return time.Duration(ttl) * time.Second
+		}
+	}
+	return negativeTTL
+}
+
+func roundTrip(ctx context.Context, network, server string, packet []byte) (*dnsmessage.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	buf := make([]byte, 65535)
+	var n int
+	if network == "tcp" {
+		// DNS over TCP prefixes every message with its length.
+		framed := make([]byte, 2+len(packet))
+		binary.BigEndian.PutUint16(framed, uint16(len(packet)))
+		copy(framed[2:], packet)
+		if _, err := conn.Write(framed); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return nil, err
+		}
+		n = int(binary.BigEndian.Uint16(buf[:2]))
+		if _, err := io.ReadFull(conn, buf[:n]); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(packet); err != nil {
+			return nil, err
+		}
+		if n, err = conn.Read(buf); err != nil {
+			return nil, err
+		}
+	}
+
+	var response dnsmessage.Message
+	if err := response.Unpack(buf[:n]); err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
@@ -0,0 +1,215 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/clodevo/raven-proxy/pkg/utils"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// queryTimeout bounds a single query to one DNS server before failing over to the next.
+	queryTimeout = 2 * time.Second
+	// systemTimeout bounds a lookup through the host resolver.
+	systemTimeout = 5 * time.Second
+	// systemTTL is used to cache answers from the host resolver, which does not report TTLs.
+	systemTTL = 30 * time.Second
+	// negativeTTL caches NXDOMAIN and empty answers when the server gives no SOA minimum.
+	negativeTTL = 10 * time.Second
+	minTTL      = 5 * time.Second
+	maxTTL      = 1 * time.Hour
+)
+
+// ErrNotFound is returned for names that have no A or AAAA records.
+var ErrNotFound = errors.New("no such host")
+
+// Resolver resolves host names through the configured DNS servers, trying
+// them in order and caching answers (including negative ones) for their TTL.
+// Without configured servers it falls back to the host resolver.
+type Resolver struct {
+	servers   []string
+	preferred atomic.Int32
+	logger    *utils.Logger
+
+	mu    sync.Mutex
+	cache map[string]*cacheEntry
+	// lookups coalesces concurrent cache misses for the same name into a
+	// single upstream query.
+	lookups singleflight.Group
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type cacheEntry struct {
+	ips     []net.IP
+	err     error
+	expires time.Time
+}
+
+// New returns a Resolver querying servers, given as "ip" or "ip:port".
+func New(servers []string, logger *utils.Logger) *Resolver {
+	r := &Resolver{
+		logger: logger,
+		cache:  make(map[string]*cacheEntry),
+	}
+	for _, server := range servers {
+		server = strings.TrimSpace(server)
+		if server == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		r.servers = append(r.servers, server)
+	}
+	return r
+}
+
+// LookupIP returns the addresses of host. IP literals are returned as is.
+func (r *Resolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	name := strings.ToLower(strings.TrimSuffix(host, "."))
+
+	r.mu.Lock()
+	entry, found := r.cache[name]
+	r.mu.Unlock()
+	if found && time.Now().Before(entry.expires) {
+		r.hits.Add(1)
+		r.logger.Trace("DNS cache hit for %s", name)
+		return entry.ips, entry.err
+	}
+	r.misses.Add(1)
+
+	// The lookup is shared with the callers that miss the cache for the
+	// same name in the meantime, so it runs on its own deadline rather than
+	// on ctx, and each caller stops waiting when its own ctx is done.
+	results := r.lookups.DoChan(name, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), r.timeout())
+		defer cancel()
+		return r.lookup(ctx, name)
+	})
+	select {
+	case result := <-results:
+		ips, _ := result.Val.([]net.IP)
+		return ips, result.Err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// timeout bounds a lookup: a query to each server in turn, or a lookup
+// through the host resolver.
+func (r *Resolver) timeout() time.Duration {
+	if len(r.servers) == 0 {
+		return systemTimeout
+	}
+	return queryTimeout * time.Duration(len(r.servers))
+}
+
+// lookup resolves name through the servers and caches the answer.
+func (r *Resolver) lookup(ctx context.Context, name string) ([]net.IP, error) {
+	start := time.Now()
+	ips, ttl, server, err := r.resolve(ctx, name)
+	elapsed := time.Since(start)
+
+	var notFound *net.DNSError
+	if err != nil && !errors.Is(err, ErrNotFound) && !(errors.As(err, &notFound) && notFound.IsNotFound) {
+		// Transport failures are not cached so the next request retries.
+		r.logger.Debug("DNS lookup for %s failed after %s: %v", name, elapsed, err)
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.cache[name] = &cacheEntry{ips: ips, err: err, expires: time.Now().Add(ttl)}
+	r.mu.Unlock()
+
+	r.logger.Debug("Resolved %s to %v in %s via %s (ttl %s, cache hit rate %.1f%%)", name, ips, elapsed, server, ttl, r.HitRate())
+	return ips, err
+}
+
+// HitRate returns the percentage of lookups answered from the cache.
+func (r *Resolver) HitRate() float64 {
+	hits, misses := r.hits.Load(), r.misses.Load()
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) * 100 / float64(hits+misses)
+}
+
+// resolve queries the servers starting with the last one that answered, and
+// moves on to the next server whenever one fails to respond.
+func (r *Resolver) resolve(ctx context.Context, name string) ([]net.IP, time.Duration, string, error) {
+	if len(r.servers) == 0 {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			return nil, negativeTTL, "system", err
+		}
+		ips := make([]net.IP, len(addrs))
+		for i, addr := range addrs {
+			ips[i] = addr.IP
+		}
+		return ips, systemTTL, "system", nil
+	}
+
+	var lastErr error
+	first := int(r.preferred.Load())
+	for i := range r.servers {
+		index := (first + i) % len(r.servers)
+		server := r.servers[index]
+
+		ips, ttl, err := query(ctx, server, name)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			r.logger.Debug("DNS server %s failed for %s: %v", server, name, err)
+			lastErr = err
+			continue
+		}
+		r.preferred.Store(int32(index))
+		return ips, clampTTL(ttl), server, err
+	}
+	return nil, 0, "", fmt.Errorf("all DNS servers failed: %w", lastErr)
+}
+
+func clampTTL(ttl time.Duration) time.Duration {
+	if ttl < minTTL {
+		return minTTL
+	}
+	if ttl > maxTTL {
+		return maxTTL
+	}
+	return ttl
+}
+
+// LogStats starts a background goroutine that periodically writes the cache
+// hit rate to the log and drops expired entries.
+func (r *Resolver) LogStats(interval time.Duration) {
+	go r.logStats(interval)
+}
+
+func (r *Resolver) logStats(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for range ticker.C {
+		now := time.Now()
+		r.mu.Lock()
+		for name, entry := range r.cache {
+			if now.After(entry.expires) {
+				delete(r.cache, name)
+			}
+		}
+		size := len(r.cache)
+		r.mu.Unlock()
+
+		if r.hits.Load()+r.misses.Load() > 0 {
+			r.logger.Info("DNS cache: %d entries, %d hits, %d misses, hit rate %.1f%%", size, r.hits.Load(), r.misses.Load(), r.HitRate())
+		}
+	}
+}
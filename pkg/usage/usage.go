@@ -0,0 +1,370 @@
+package usage
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/clodevo/raven-proxy/pkg/database"
+	"github.com/clodevo/raven-proxy/pkg/utils"
+)
+
+// Quota periods. Periods are calendar days and months in UTC.
+const (
+	PeriodDaily   = "daily"
+	PeriodMonthly = "monthly"
+)
+
+const dayFormat = "2006-01-02"
+
+// Totals are the traffic counters of a tenant or API key. BytesIn is
+// traffic from the client, BytesOut traffic to the client.
+type Totals struct {
+	Requests uint64
+	BytesIn  uint64
+	BytesOut uint64
+}
+
+// Bytes is the volume in both directions.
+func (t Totals) Bytes() uint64 {
+	return t.BytesIn + t.BytesOut
+}
+
+func (t Totals) add(other Totals) Totals {
+	return Totals{
+		Requests: t.Requests + other.Requests,
+		BytesIn:  t.BytesIn + other.BytesIn,
+		BytesOut: t.BytesOut + other.BytesOut,
+	}
+}
+
+// PeriodBounds returns the start of the period containing now and the start of the next one.
+func PeriodBounds(period string, now time.Time) (time.Time, time.Time) {
+	now = now.UTC()
+	if period == PeriodMonthly {
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	}
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 0, 1)
+}
+
+// dayFilter returns the SQL condition and argument selecting the rows of the period containing now.
+func dayFilter(period string, now time.Time) (string, string) {
+	if period == PeriodMonthly {
+		return "day LIKE ?", now.UTC().Format("2006-01") + "-%"
+	}
+	return "day = ?", now.UTC().Format(dayFormat)
+}
+
+// Account collects the traffic of one API key (or of a tenant identified
+// without a key) until the next flush.
+type Account struct {
+	tenantID string
+	apiKeyID string
+
+	requests atomic.Uint64
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+}
+
+// AddRequest counts one request or tunnel.
+func (a *Account) AddRequest() {
+	a.requests.Add(1)
+}
+
+// AddIn counts n bytes sent by the client.
+func (a *Account) AddIn(n int) {
+	if n > 0 {
+		a.bytesIn.Add(uint64(n))
+	}
+}
+
+// AddOut counts n bytes sent to the client.
+func (a *Account) AddOut(n int) {
+	if n > 0 {
+		a.bytesOut.Add(uint64(n))
+	}
+}
+
+// CountIn returns a reader that counts the bytes read from the client through r.
+func (a *Account) CountIn(r io.Reader) io.Reader {
+	return &countingReader{r: r, add: a.AddIn}
+}
+
+// CountOut returns a reader that counts the bytes read through r on their way to the client.
+func (a *Account) CountOut(r io.Reader) io.Reader {
+	return &countingReader{r: r, add: a.AddOut}
+}
+
+func (a *Account) pending() Totals {
+	return Totals{Requests: a.requests.Load(), BytesIn: a.bytesIn.Load(), BytesOut: a.bytesOut.Load()}
+}
+
+func (a *Account) take() Totals {
+	return Totals{Requests: a.requests.Swap(0), BytesIn: a.bytesIn.Swap(0), BytesOut: a.bytesOut.Swap(0)}
+}
+
+func (a *Account) giveBack(t Totals) {
+	a.requests.Add(t.Requests)
+	a.bytesIn.Add(t.BytesIn)
+	a.bytesOut.Add(t.BytesOut)
+}
+
+type countingReader struct {
+	r   io.Reader
+	add func(int)
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.add(n)
+	return n, err
+}
+
+// Meter keeps the traffic counters of every tenant and API key in memory
+// and periodically adds them to the usage_counters table. Usage reported
+// for quotas is what the database held at the last flush plus what is
+// still pending, so that several proxies sharing a database see each
+// other's traffic within one flush interval.
+type Meter struct {
+	mu       sync.Mutex
+	accounts map[string]map[string]*Account // tenant ID -> API key ID -> account
+	flushed  map[string]*flushedTotals      // tenant ID -> totals read from the database
+	flushMu  sync.Mutex
+	logger   *utils.Logger
+}
+
+type flushedTotals struct {
+	day        string
+	dayTotals  Totals
+	month      string
+	monthTotal Totals
+}
+
+var meter *Meter // Singleton instance of Meter
+
+func init() {
+	meter = NewMeter(utils.GetLogger())
+}
+
+func NewMeter(logger *utils.Logger) *Meter {
+	return &Meter{
+		accounts: make(map[string]map[string]*Account),
+		flushed:  make(map[string]*flushedTotals),
+		logger:   logger,
+	}
+}
+
+// GetMeter returns the singleton instance of the meter.
+func GetMeter() *Meter {
+	return meter
+}
+
+// Account returns the account that the traffic of the identity is counted on.
+func (m *Meter) Account(id *utils.Identity) *Account {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys, exists := m.accounts[id.TenantID]
+	if !exists {
+		keys = make(map[string]*Account)
+		m.accounts[id.TenantID] = keys
+	}
+	account, exists := keys[id.APIKeyID]
+	if !exists {
+		account = &Account{tenantID: id.TenantID, apiKeyID: id.APIKeyID}
+		keys[id.APIKeyID] = account
+	}
+	return account
+}
+
+// Start flushes the counters to the database every interval in a background
+// goroutine. A non-positive interval leaves flushing to explicit Flush calls.
+func (m *Meter) Start(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			if err := m.Flush(); err != nil {
+				m.logger.Info("Error flushing usage counters: %v", err)
+			}
+		}
+	}()
+}
+
+// Flush adds the pending counters to today's rows and refreshes the
+// totals used for quota checks. Counters that cannot be written are kept
+// for the next flush.
+func (m *Meter) Flush() error {
+	m.flushMu.Lock()
+	defer m.flushMu.Unlock()
+
+	m.mu.Lock()
+	var accounts []*Account
+	tenants := make(map[string]bool, len(m.accounts))
+	for tenantID, keys := range m.accounts {
+		tenants[tenantID] = true
+		for _, account := range keys {
+			accounts = append(accounts, account)
+		}
+	}
+	m.mu.Unlock()
+
+	now := time.Now()
+	day := now.UTC().Format(dayFormat)
+	var firstErr error
+	for _, account := range accounts {
+		delta := account.take()
+		if delta == (Totals{}) {
+			continue
+		}
+		if err := addCounters(account.tenantID, account.apiKeyID, day, delta); err != nil {
+			account.giveBack(delta)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	for tenantID := range tenants {
+		if err := m.refresh(tenantID, now); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// addCounters adds delta to a day row, creating the row when needed.
+func addCounters(tenantID, apiKeyID, day string, delta Totals) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+        UPDATE usage_counters
+        SET requests = requests + ?, bytes_in = bytes_in + ?, bytes_out = bytes_out + ?, updated_at = CURRENT_TIMESTAMP
+        WHERE tenant_id = ? AND api_key_id = ? AND day = ?`,
+		delta.Requests, delta.BytesIn, delta.BytesOut, tenantID, apiKeyID, day)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		_, err = tx.Exec(`
+            INSERT INTO usage_counters (tenant_id, api_key_id, day, requests, bytes_in, bytes_out)
+            VALUES (?, ?, ?, ?, ?, ?)`,
+			tenantID, apiKeyID, day, delta.Requests, delta.BytesIn, delta.BytesOut)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// refresh reloads the day and month totals of a tenant from the database.
+func (m *Meter) refresh(tenantID string, now time.Time) error {
+	dayTotals, err := queryTotals(tenantID, PeriodDaily, now)
+	if err != nil {
+		return err
+	}
+	monthTotals, err := queryTotals(tenantID, PeriodMonthly, now)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.flushed[tenantID] = &flushedTotals{
+		day:        now.UTC().Format(dayFormat),
+		dayTotals:  dayTotals,
+		month:      now.UTC().Format("2006-01"),
+		monthTotal: monthTotals,
+	}
+	m.mu.Unlock()
+	return nil
+}
+
+func queryTotals(tenantID, period string, now time.Time) (Totals, error) {
+	filter, arg := dayFilter(period, now)
+	var totals Totals
+	err := database.DB.QueryRow(`
+        SELECT COALESCE(SUM(requests), 0), COALESCE(SUM(bytes_in), 0), COALESCE(SUM(bytes_out), 0)
+        FROM usage_counters
+        WHERE tenant_id = ? AND `+filter, tenantID, arg).Scan(&totals.Requests, &totals.BytesIn, &totals.BytesOut)
+	return totals, err
+}
+
+// Usage returns the traffic of a tenant in the current period, including
+// traffic not flushed yet.
+func (m *Meter) Usage(tenantID, period string) (Totals, error) {
+	now := time.Now()
+	day, month := now.UTC().Format(dayFormat), now.UTC().Format("2006-01")
+
+	m.mu.Lock()
+	flushed := m.flushed[tenantID]
+	m.mu.Unlock()
+	if flushed == nil || flushed.day != day || flushed.month != month {
+		if err := m.refresh(tenantID, now); err != nil {
+			return Totals{}, fmt.Errorf("loading usage of tenant %s: %w", tenantID, err)
+		}
+		m.mu.Lock()
+		flushed = m.flushed[tenantID]
+		m.mu.Unlock()
+	}
+
+	totals := flushed.dayTotals
+	if period == PeriodMonthly {
+		totals = flushed.monthTotal
+	}
+
+	m.mu.Lock()
+	for _, account := range m.accounts[tenantID] {
+		totals = totals.add(account.pending())
+	}
+	m.mu.Unlock()
+	return totals, nil
+}
+
+// KeyUsage returns the traffic of each API key of a tenant in the current
+// period, including traffic not flushed yet. Traffic without an API key is
+// reported under the empty key.
+func (m *Meter) KeyUsage(tenantID, period string) (map[string]Totals, error) {
+	filter, arg := dayFilter(period, time.Now())
+	rows, err := database.DB.Query(`
+        SELECT api_key_id, SUM(requests), SUM(bytes_in), SUM(bytes_out)
+        FROM usage_counters
+        WHERE tenant_id = ? AND `+filter+`
+        GROUP BY api_key_id`, tenantID, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[string]Totals)
+	for rows.Next() {
+		var apiKeyID sql.NullString
+		var totals Totals
+		if err := rows.Scan(&apiKeyID, &totals.Requests, &totals.BytesIn, &totals.BytesOut); err != nil {
+			return nil, err
+		}
+		keys[apiKeyID.String] = totals
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	for apiKeyID, account := range m.accounts[tenantID] {
+		keys[apiKeyID] = keys[apiKeyID].add(account.pending())
+	}
+	m.mu.Unlock()
+	return keys, nil
+}
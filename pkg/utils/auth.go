@@ -2,37 +2,48 @@ package utils
 
 import (
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"strings"
 
 	"github.com/clodevo/raven-proxy/pkg/database"
 	"github.com/valyala/fasthttp"
 )
 
-var TenantNameRequest string
-
 // Create a global instance of AuthCache
 var authCache = NewAuthCache()
 
-func Authenticate(ctx *fasthttp.RequestCtx) bool {
+// tenantCache caches tenant lookups by name for listeners that identify the
+// tenant without credentials. It is kept apart from authCache so that an
+// entry in it can never stand in for an API key check.
+var tenantCache = NewAuthCache()
+
+// ErrInvalidCredentials is returned when a tenant_name and api_key pair does not match any API key.
+var ErrInvalidCredentials = errors.New("Unauthorized: Invalid tenant_name or api_key")
+
+// Authenticate validates the Proxy-Authorization header of the request and
+// returns the identity of the calling tenant. On failure the response is
+// already populated and the returned identity is nil.
+func Authenticate(ctx *fasthttp.RequestCtx) (*Identity, bool) {
 	auth := string(ctx.Request.Header.Peek("Proxy-Authorization"))
 	if auth == "" {
 		ctx.Response.SetStatusCode(fasthttp.StatusUnauthorized)
 		ctx.Response.SetBodyString("Unauthorized: Authorization header required")
-		return false
+		return nil, false
 	}
 
 	parts := strings.SplitN(auth, " ", 2)
 	if len(parts) != 2 || parts[0] != "Basic" {
 		ctx.Response.SetStatusCode(fasthttp.StatusUnauthorized)
 		ctx.Response.SetBodyString("Unauthorized: Invalid Authorization format")
-		return false
+		return nil, false
 	}
 
 	decoded, err := base64.StdEncoding.DecodeString(parts[1])
 	if err != nil {
 		ctx.Response.SetStatusCode(fasthttp.StatusUnauthorized)
 		ctx.Response.SetBodyString("Unauthorized: Invalid Base64 encoding")
-		return false
+		return nil, false
 	}
 
 	// Expecting the format to be "tenant_name:api_key"
@@ -40,39 +51,61 @@ func Authenticate(ctx *fasthttp.RequestCtx) bool {
 	if len(creds) != 2 {
 		ctx.Response.SetStatusCode(fasthttp.StatusUnauthorized)
 		ctx.Response.SetBodyString("Unauthorized: tenant_name and api_key required")
-		return false
+		return nil, false
 	}
 
 	tenantName, apiKey := creds[0], creds[1]
 
-	if valid, cachedTenantName := authCache.Check(tenantName, apiKey); valid {
+	id, err := VerifyCredentials(tenantName, apiKey, ctx.RemoteAddr().String())
+	if err != nil {
+		ctx.Response.SetStatusCode(fasthttp.StatusUnauthorized)
+		ctx.Response.SetBodyString(err.Error())
+		return nil, false
+	}
+	return id, true
+}
+
+// VerifyCredentials checks a tenant_name and api_key pair and returns the
+// identity of the tenant. It is shared by every listener that authenticates
+// tenants, whatever the protocol carrying the credentials.
+func VerifyCredentials(tenantName, apiKey, clientAddr string) (*Identity, error) {
+	if valid, cached := authCache.Check(tenantName, apiKey); valid {
 		// Cache hit and not expired, consider authenticated
-		TenantNameRequest = cachedTenantName
-		return true
+		return NewIdentity(cached.TenantID, cached.TenantName, cached.APIKeyID, clientAddr), nil
 	}
 
 	// Verify tenant_name and api_key against the database
-	var dbTenantName string
-	err = database.DB.QueryRow(`
-        SELECT tenants.tenant_name 
+	var tenantID, apiKeyID string
+	err := database.DB.QueryRow(`
+        SELECT tenants.tenant_id, api_keys.api_key_id
         FROM api_keys 
         JOIN tenants ON api_keys.tenant_id = tenants.tenant_id 
-        WHERE api_keys.api_key = ? AND tenants.tenant_name = ?`, apiKey, tenantName).Scan(&dbTenantName)
+        WHERE api_keys.api_key = ? AND tenants.tenant_name = ?`, apiKey, tenantName).Scan(&tenantID, &apiKeyID)
 
 	if err != nil {
 		// If the query fails, the API key or tenant_name is invalid
-		ctx.Response.SetStatusCode(fasthttp.StatusUnauthorized)
-		ctx.Response.SetBodyString("Unauthorized: Invalid tenant_name or api_key")
-		return false
+		return nil, ErrInvalidCredentials
 	}
 
-	if err == nil {
-		// Update cache on successful authentication
-		authCache.Update(tenantName, apiKey)
+	// Update cache on successful authentication
+	authCache.Update(tenantName, apiKey, tenantID, apiKeyID)
+
+	return NewIdentity(tenantID, tenantName, apiKeyID, clientAddr), nil
+}
+
+// IdentifyTenant returns the identity of a tenant selected by name rather
+// than by credentials, as done by the transparent proxy. The identity has no API key ID.
+func IdentifyTenant(tenantName, clientAddr string) (*Identity, error) {
+	if valid, cached := tenantCache.Check(tenantName, ""); valid {
+		return NewIdentity(cached.TenantID, cached.TenantName, "", clientAddr), nil
 	}
 
-	// If the API key and tenant_name are valid, optionally add the tenant_name to the response header
-	TenantNameRequest = tenantName
+	var tenantID string
+	err := database.DB.QueryRow("SELECT tenant_id FROM tenants WHERE tenant_name = ?", tenantName).Scan(&tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("unknown tenant %s: %w", tenantName, err)
+	}
 
-	return true
+	tenantCache.Update(tenantName, "", tenantID, "")
+	return NewIdentity(tenantID, tenantName, "", clientAddr), nil
 }
@@ -6,7 +6,9 @@ import (
 )
 
 type AuthCacheItem struct {
+	TenantID   string
 	TenantName string
+	APIKeyID   string
 	Expiry     time.Time
 }
 
@@ -23,9 +25,9 @@ func NewAuthCache() *AuthCache {
 }
 
 // Check looks up the cache for the given tenantName and apiKey.
-// It returns true if the credentials are valid and found in the cache, along with the tenant name.
+// It returns true if the credentials are valid and found in the cache, along with the cached item.
 // If the credentials are not found or expired, it returns false.
-func (c *AuthCache) Check(tenantName, apiKey string) (bool, string) {
+func (c *AuthCache) Check(tenantName, apiKey string) (bool, AuthCacheItem) {
 	cacheKey := tenantName + ":" + apiKey
 
 	c.mutex.Lock()
@@ -33,22 +35,24 @@ func (c *AuthCache) Check(tenantName, apiKey string) (bool, string) {
 
 	if item, found := c.items[cacheKey]; found && item.Expiry.After(time.Now()) {
 		// Cache hit and not expired
-		return true, item.TenantName
+		return true, item
 	}
 
 	// Not found in cache or expired
-	return false, ""
+	return false, AuthCacheItem{}
 }
 
 // Update adds or updates the cache with the given tenantName and apiKey.
-func (c *AuthCache) Update(tenantName, apiKey string) {
+func (c *AuthCache) Update(tenantName, apiKey, tenantID, apiKeyID string) {
 	cacheKey := tenantName + ":" + apiKey
 
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
 	c.items[cacheKey] = AuthCacheItem{
+		TenantID:   tenantID,
 		TenantName: tenantName,
+		APIKeyID:   apiKeyID,
 		Expiry:     time.Now().Add(5 * time.Minute), // Adjust expiry time as needed
 	}
 }
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// Identity describes who issued a proxied request. It is produced by
+// Authenticate and carried through the proxy pipeline so that every
+// decision made for a request refers to that request's tenant only.
+type Identity struct {
+	TenantID   string
+	TenantName string
+	APIKeyID   string
+	ClientAddr string
+	RequestID  string
+}
+
+// NewIdentity builds an Identity for an authenticated tenant and assigns it a fresh request ID.
+func NewIdentity(tenantID, tenantName, apiKeyID, clientAddr string) *Identity {
+	return &Identity{
+		TenantID:   tenantID,
+		TenantName: tenantName,
+		APIKeyID:   apiKeyID,
+		ClientAddr: clientAddr,
+		RequestID:  uuid.NewString(),
+	}
+}
+
+// String formats the identity for log lines.
+func (i *Identity) String() string {
+	return fmt.Sprintf("req=%s tenant=%s key=%s client=%s", i.RequestID, i.TenantName, i.APIKeyID, i.ClientAddr)
+}